@@ -70,4 +70,18 @@ Define the namespace to use
 {{- else }}
 {{- .Release.Namespace }}
 {{- end }}
-{{- end }}
\ No newline at end of file
+{{- end }}
+{{/*
+旧版本保存acme.sh账户数据的PVC名称，没有需要挂载的PVC时为空
+未设置existingClaim时，启用persistence或集群中已存在旧版本创建的PVC都会继续使用该PVC
+*/}}
+{{- define "autocert.legacyClaim" -}}
+{{- if .Values.persistence.existingClaim }}
+{{- .Values.persistence.existingClaim }}
+{{- else }}
+{{- $name := printf "%s-acme" (include "autocert.fullname" .) }}
+{{- if or .Values.persistence.enabled (lookup "v1" "PersistentVolumeClaim" (include "autocert.namespace" .) $name) }}
+{{- $name }}
+{{- end }}
+{{- end }}
+{{- end }}
@@ -77,7 +77,7 @@ if [ -z "$ACTION" ] || [ -z "$DOMAIN" ]; then
 fi
 
 # 构建acme.sh命令 - 添加 --config-home 参数确保使用正确的配置目录
-CMD="$ACME_SH $ACTION -d $DOMAIN --config-home /acme.sh"
+CMD="$ACME_SH $ACTION -d $DOMAIN --config-home ${LE_CONFIG_HOME:-/tmp/acme.sh}"
 
 # 如果是签发操作，添加DNS提供商
 if [ "$ACTION" == "--issue" ] && [ ! -z "$DNS_PROVIDER" ]; then
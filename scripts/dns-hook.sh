@@ -0,0 +1,40 @@
+#!/bin/bash
+# dns-hook.sh - 调用acme.sh自带的dnsapi脚本添加或删除DNS-01验证用的TXT记录
+# 用法: dns-hook.sh add|rm <dns_provider> <fqdn> <txt_value>
+
+set -e
+
+ACTION="$1"
+DNS_PROVIDER="$2"
+FQDN="$3"
+TXT_VALUE="$4"
+
+if [ "$ACTION" != "add" ] && [ "$ACTION" != "rm" ] || [ -z "$DNS_PROVIDER" ] || [ -z "$FQDN" ] || [ -z "$TXT_VALUE" ]; then
+    echo "用法: $0 add|rm dns_provider fqdn txt_value"
+    exit 1
+fi
+
+# acme.sh 路径 - 使用软链接，这在 neilpang/acme.sh 镜像中是可用的
+ACME_SH="/usr/local/bin/acme.sh"
+if [ ! -f "$ACME_SH" ] && [ ! -L "$ACME_SH" ]; then
+    ACME_SH="/root/.acme.sh/acme.sh"
+fi
+
+# 以库的方式加载acme.sh，不带参数时它只会输出帮助信息
+. "$ACME_SH" >/dev/null 2>&1
+_initpath
+
+# 查找并加载dnsapi脚本
+HOOK_FILE="$(_findHook "$FQDN" "$_SUB_FOLDER_DNSAPI" "$DNS_PROVIDER")"
+if [ -z "$HOOK_FILE" ]; then
+    echo "错误: 找不到DNS提供商 $DNS_PROVIDER 对应的dnsapi脚本"
+    exit 1
+fi
+. "$HOOK_FILE"
+
+if ! "${DNS_PROVIDER}_${ACTION}" "$FQDN" "$TXT_VALUE"; then
+    echo "错误: ${DNS_PROVIDER}_${ACTION} 执行失败"
+    exit 1
+fi
+
+echo "${DNS_PROVIDER}_${ACTION} 执行成功: $FQDN"
@@ -2,13 +2,20 @@ package controllers
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"gopkg.in/yaml.v3"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
 	"me.sttot/auto-cert/src/models"
@@ -23,10 +30,16 @@ var (
 	ConfigSecretNamespace = getEnvOrDefault("CONFIG_SECRET_NAMESPACE", "default")
 	ConfigMapKey          = getEnvOrDefault("CONFIG_MAP_KEY", "config.yaml")
 
-	// 证书检查周期
-	CheckInterval = getCheckIntervalFromEnv()
+	// 并行处理证书的工作协程数量
+	WorkerCount = getIntFromEnv("WORKER_COUNT", 4)
+
+	// 配置变更导致重新签发后，是否吊销被替换的旧证书
+	RevokeSuperseded = getEnvOrDefault("REVOKE_SUPERSEDED", "false") == "true"
 )
 
+// 续签计划的最短延迟，避免续签时间已过（例如续签失败后仍在使用旧证书）时反复签发
+const minRenewalDelay = time.Hour
+
 // getEnvOrDefault 从环境变量获取值，如果不存在则返回默认值
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -35,37 +48,68 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-// getCheckIntervalFromEnv 从环境变量获取检查周期
-func getCheckIntervalFromEnv() time.Duration {
-	value := os.Getenv("CHECK_INTERVAL")
+// getIntFromEnv 从环境变量解析正整数，如果不存在或无法解析则返回默认值
+func getIntFromEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
 	if value == "" {
-		return 24 * time.Hour // 默认为24小时
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		utils.WarningLog("无法解析%s环境变量 '%s', 使用默认值%d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// getDurationFromEnv 从环境变量解析时间间隔，如果不存在或无法解析则返回默认值
+func getDurationFromEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
 
-	// 尝试解析时间字符串
 	duration, err := time.ParseDuration(value)
 	if err != nil {
-		utils.WarningLog("无法解析CHECK_INTERVAL环境变量 '%s', 使用默认值24小时: %v", value, err)
-		return 24 * time.Hour
+		utils.WarningLog("无法解析%s环境变量 '%s', 使用默认值%s: %v", key, value, defaultValue, err)
+		return defaultValue
 	}
 	return duration
 }
 
 type CertificateController struct {
-	clientset          *kubernetes.Clientset
+	clientset          kubernetes.Interface
+	dynamicClient      dynamic.Interface
 	certificateService *services.CertificateService
-	acmeService        *services.AcmeService
+	statusService      *services.StatusService
+	issuers            *services.IssuerRegistry
 	queue              workqueue.RateLimitingInterface
+	resourceLister     cache.Indexer // Certificate 资源的本地缓存
 	stopCh             chan struct{}
+
+	configMu    sync.Mutex
+	configCerts map[string]*models.Certificate // 最近一次加载的配置，按证书名称索引
+	configTimer *time.Timer                    // 配置变更的防抖定时器
+
+	renewalInfoMu    sync.Mutex
+	renewalInfoCache map[string]*services.RenewalInfo // 各证书最近一次查询到的ARI续签信息
+
+	configErrorMu   sync.Mutex
+	configErrorKeys map[string]bool // 因配置错误停止重试的证书，配置Secret变化后重新处理
 }
 
-func NewCertificateController(clientset *kubernetes.Clientset, certService *services.CertificateService, acmeService *services.AcmeService) *CertificateController {
+func NewCertificateController(clientset kubernetes.Interface, dynamicClient dynamic.Interface, certService *services.CertificateService, statusService *services.StatusService, issuers *services.IssuerRegistry) *CertificateController {
 	controller := &CertificateController{
 		clientset:          clientset,
+		dynamicClient:      dynamicClient,
 		certificateService: certService,
-		acmeService:        acmeService,
+		statusService:      statusService,
+		issuers:            issuers,
 		queue:              workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "certificates"),
 		stopCh:             make(chan struct{}),
+		renewalInfoCache:   make(map[string]*services.RenewalInfo),
+		configErrorKeys:    make(map[string]bool),
 	}
 
 	return controller
@@ -74,34 +118,23 @@ func NewCertificateController(clientset *kubernetes.Clientset, certService *serv
 // Start 启动证书控制器
 func (c *CertificateController) Start(ctx context.Context) error {
 	utils.InfoLog("启动证书控制器")
-	utils.DebugLog("证书检查周期为 %s", CheckInterval)
-
-	// 立即处理所有证书
-	if err := c.ProcessAllCertificates(ctx); err != nil {
-		utils.ErrorLog("初始处理证书失败: %v", err)
-	}
-
-	// 启动定期检查证书的goroutine
-	// 这里使用time.Sleep和单独的goroutine替代wait.Until的立即执行特性
-	// 这样可以避免在启动时重复执行ProcessAllCertificates
-	go func() {
-		utils.DebugLog("启动定期证书检查任务，首次检查将在 %s 后执行", CheckInterval)
-		ticker := time.NewTicker(CheckInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				utils.DebugLog("执行定期证书检查任务")
-				if err := c.ProcessAllCertificates(ctx); err != nil {
-					utils.ErrorLog("定期处理证书失败: %v", err)
-				}
-			case <-c.stopCh:
-				utils.DebugLog("定期证书检查任务已停止")
-				return
-			}
-		}
-	}()
+
+	// 启动工作队列的处理协程
+	// 工作队列保证同一个键不会同时交给两个协程，因此同一证书的处理总是串行的
+	utils.DebugLog("启动 %d 个证书处理协程", WorkerCount)
+	for i := 0; i < WorkerCount; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	// 监听 Certificate 资源，资源变更通过工作队列处理
+	if err := c.startResourceWatcher(ctx); err != nil {
+		return err
+	}
+
+	// 监听配置Secret，首次同步时所有证书都会作为新增证书加入工作队列
+	if err := c.startConfigWatcher(ctx); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -111,6 +144,67 @@ func (c *CertificateController) Stop() {
 	utils.InfoLog("停止证书控制器")
 	close(c.stopCh)
 	c.queue.ShutDown()
+
+	c.configMu.Lock()
+	if c.configTimer != nil {
+		c.configTimer.Stop()
+	}
+	c.configMu.Unlock()
+}
+
+// runWorker 持续处理工作队列中的证书
+func (c *CertificateController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+// processNextItem 处理工作队列中的一个证书，失败时按限速策略重新入队
+// 配置错误重试不会成功，不再入队，等配置Secret或证书资源变化后再处理
+// 键为 namespace/name 的是 Certificate 资源，其余为配置Secret中的证书名称
+func (c *CertificateController) processNextItem(ctx context.Context) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(string)
+	var err error
+	if strings.Contains(key, "/") {
+		err = c.syncResource(ctx, key)
+	} else {
+		err = c.syncConfigCertificate(ctx, key)
+	}
+	var configErr *services.ConfigError
+	switch {
+	case err == nil:
+	case errors.As(err, &configErr):
+		utils.ErrorLog("证书 %s 配置错误，修改配置后再处理: %v", key, err)
+		c.configErrorMu.Lock()
+		c.configErrorKeys[key] = true
+		c.configErrorMu.Unlock()
+	default:
+		utils.ErrorLog("处理证书 %s 失败，稍后重试: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// requeueConfigErrors 将因配置错误停止处理的证书重新放入工作队列
+// 这些证书可能引用了签发者、账户或Secret，它们的变化不体现在证书自身的配置中
+func (c *CertificateController) requeueConfigErrors() {
+	c.configErrorMu.Lock()
+	keys := c.configErrorKeys
+	c.configErrorKeys = make(map[string]bool)
+	c.configErrorMu.Unlock()
+
+	for key := range keys {
+		utils.DebugLog("配置已更新，重新处理之前配置错误的证书 %s", key)
+		c.queue.Add(key)
+	}
 }
 
 // LoadCertificatesFromConfig 从配置Secret加载证书配置
@@ -127,90 +221,131 @@ func (c *CertificateController) LoadCertificatesFromConfig(ctx context.Context)
 		return nil, fmt.Errorf("配置Secret中没有找到config.yaml")
 	}
 
-	// 解析YAML配置
+	// 严格解析并校验YAML配置
 	utils.DebugLog("解析证书配置YAML数据")
-	certs, err := parseYamlConfig(configYaml)
+	config, err := services.ParseConfig(configYaml)
 	if err != nil {
 		return nil, fmt.Errorf("解析证书配置失败: %v", err)
 	}
 
-	utils.DebugLog("成功加载了%d个证书配置", len(certs))
+	// 根据配置更新签发者
+	if err := c.issuers.Configure(config.Issuers); err != nil {
+		return nil, fmt.Errorf("配置签发者失败: %v", err)
+	}
+	c.issuers.ACME().Accounts().Configure(config.Accounts, ConfigSecretNamespace)
+
+	var certs []*models.Certificate
+	for i := range config.Domains {
+		certs = append(certs, &config.Domains[i])
+	}
+
+	utils.DebugLog("成功加载了%d个证书配置和%d个签发者", len(certs), len(config.Issuers))
 	return certs, nil
 }
 
-// ProcessAllCertificates 处理所有证书
-func (c *CertificateController) ProcessAllCertificates(ctx context.Context) error {
-	// 从配置加载证书
-	utils.DebugLog("开始处理所有证书")
+// ProcessCertificate 处理单个证书并记录其状态
+func (c *CertificateController) ProcessCertificate(ctx context.Context, cert *models.Certificate) error {
+	stored, err := c.processCertificate(ctx, cert)
+	c.recordStatus(ctx, cert.Name, stored, err)
+	if err == nil && stored != nil {
+		c.scheduleRenewal(ctx, cert.Name, stored)
+	}
+	return err
+}
 
-	certs, err := c.LoadCertificatesFromConfig(ctx)
+// scheduleRenewal 计算下一次续签或重新查询续签窗口的时间，并在那时将证书重新放入工作队列
+func (c *CertificateController) scheduleRenewal(ctx context.Context, key string, stored *models.Certificate) {
+	leaf, err := c.certificateService.ParseCertificateData(stored.CertData)
 	if err != nil {
-		return fmt.Errorf("加载证书配置失败: %v", err)
+		utils.ErrorLog("解析证书 %s 失败，无法安排续签: %v", key, err)
+		return
 	}
 
-	utils.DebugLog("准备处理%d个证书", len(certs))
-	for _, cert := range certs {
-		utils.DebugLog("开始处理证书 %s", cert.Name)
-		if err := c.ProcessCertificate(ctx, cert); err != nil {
-			utils.ErrorLog("处理证书 %s 失败: %v", cert.Name, err)
-			// 继续处理下一个证书
-			continue
-		}
+	renewalTime, recheckTime := c.renewalTime(ctx, stored, leaf)
+	delay := time.Until(recheckTime)
+	if delay < minRenewalDelay {
+		delay = minRenewalDelay
 	}
 
-	utils.DebugLog("所有证书处理完成")
-	return nil
+	utils.InfoLog("证书 %s 计划于 %s 续签，下一次检查时间为 %s", key, renewalTime.Format(time.RFC3339), time.Now().Add(delay).Format(time.RFC3339))
+	c.queue.AddAfter(key, delay)
 }
 
-// ProcessCertificate 处理单个证书
-func (c *CertificateController) ProcessCertificate(ctx context.Context, cert *models.Certificate) error {
+// processCertificate 按需签发或续签证书，返回当前存储的证书（可能为nil）
+// 首次签发失败时返回本次处理的证书（没有证书数据），以便在状态中展示DNS记录的生效情况
+func (c *CertificateController) processCertificate(ctx context.Context, cert *models.Certificate) (*models.Certificate, error) {
 	utils.InfoLog("处理证书: %s, 域名: %v", cert.Name, cert.Domains)
-	utils.DebugLog("证书提供方: %s, 服务器: %s", cert.DNSProvider, cert.Server)
+	utils.DebugLog("证书提供方: %s, 服务器: %s, 签发者: %s", cert.DNSProvider, cert.Server, cert.Issuer)
+
+	issuer, err := c.issuers.Get(cert.Issuer)
+	if err != nil {
+		return nil, &services.ConfigError{Err: fmt.Errorf("获取签发者失败: %v", err)}
+	}
 
 	// 从存储中获取证书
 	existingCert, err := c.certificateService.GetCertificate(ctx, cert.Name)
 	if err != nil {
-		return fmt.Errorf("获取证书信息失败: %v", err)
+		return nil, fmt.Errorf("获取证书信息失败: %v", err)
 	}
 
 	// 如果证书不存在，则尝试颁发新证书
 	if existingCert == nil {
 		utils.InfoLog("证书 %s 不存在，颁发新证书", cert.Name)
 		utils.DebugLog("开始为域名 %v 颁发新证书", cert.Domains)
+		if err := c.certificateService.ResolveEnvs(ctx, cert, ConfigSecretNamespace); err != nil {
+			return cert, err
+		}
+		if err := c.certificateService.ResolveEAB(ctx, cert, ConfigSecretNamespace); err != nil {
+			return cert, err
+		}
+		c.markIssuing(ctx, cert.Name, "NotIssued", "证书尚未签发")
 
-		if err := c.acmeService.IssueCertificate(ctx, cert); err != nil {
-			return fmt.Errorf("颁发证书失败: %v", err)
+		if err := issuer.Issue(ctx, cert); err != nil {
+			return cert, wrapIssueError("颁发证书失败", err)
 		}
 
 		// 存储证书信息
 		utils.DebugLog("存储新颁发的证书信息")
 		if err := c.certificateService.StoreCertificate(ctx, cert); err != nil {
-			return fmt.Errorf("存储证书信息失败: %v", err)
+			return nil, fmt.Errorf("存储证书信息失败: %v", err)
 		}
 
 		// 更新Secret
 		utils.DebugLog("更新Secret中的证书数据")
 		if err := c.certificateService.UpdateSecrets(ctx, cert); err != nil {
-			return fmt.Errorf("更新Secret失败: %v", err)
+			return cert, fmt.Errorf("更新Secret失败: %v", err)
 		}
 
 		utils.InfoLog("颁发并存储证书 %s 成功", cert.Name)
-		return nil
+		return cert, nil
 	}
 
+	// 续签窗口以当前配置为准
+	existingCert.RenewBefore = cert.RenewBefore
+	existingCert.RenewBeforePercentage = cert.RenewBeforePercentage
+
 	// 检查证书是否过期或即将过期
 	needsRenewal := false
-	if existingCert.CertData != "" {
+	renewalReason, renewalMessage := "Renewing", "证书即将过期，正在续签"
+	if drift := c.certificateService.CheckSpecDrift(existingCert, cert, c.issuers.ACME().CertServer); drift != "" {
+		// 域名、DNS提供商、服务器或签发者发生变化时，不论证书是否有效都重新签发
+		utils.InfoLog("证书 %s 的配置已变更，需要重新签发: %s", cert.Name, drift)
+		needsRenewal = true
+		renewalReason, renewalMessage = "SpecChanged", drift
+	} else if existingCert.CertData != "" {
 		utils.DebugLog("检查证书 %s 是否需要续签", cert.Name)
-		var expiryTime time.Time
-		needsRenewal, expiryTime, err = c.certificateService.CheckCertificateExpiry(existingCert.CertData)
+		leaf, err := c.certificateService.ParseCertificateData(existingCert.CertData)
 		if err != nil {
 			utils.ErrorLog("检查证书有效期失败: %v，尝试续签", err)
 			needsRenewal = true
-		} else if needsRenewal {
-			utils.InfoLog("证书 %s 将在 %s 过期，需要续签", cert.Name, expiryTime.Format("2006-01-02"))
 		} else {
-			utils.InfoLog("证书 %s 有效期至 %s，不需要续签", cert.Name, expiryTime.Format("2006-01-02"))
+			renewalTime, _ := c.renewalTime(ctx, existingCert, leaf)
+			needsRenewal = !time.Now().Before(renewalTime)
+			if needsRenewal {
+				utils.InfoLog("证书 %s 将在 %s 过期，已到续签时间 %s，需要续签", cert.Name, leaf.NotAfter.Format("2006-01-02"), renewalTime.Format(time.RFC3339))
+			} else {
+				utils.InfoLog("证书 %s 有效期至 %s，将于 %s 续签", cert.Name, leaf.NotAfter.Format("2006-01-02"), renewalTime.Format(time.RFC3339))
+			}
 		}
 	} else {
 		// 如果没有证书数据，也需要续签
@@ -220,58 +355,169 @@ func (c *CertificateController) ProcessCertificate(ctx context.Context, cert *mo
 
 	// 如果需要续签，则尝试续签
 	if needsRenewal {
+		// 配置变更时保留被替换的证书，新证书写入所有Secret后再吊销
+		var superseded *models.Certificate
+		if RevokeSuperseded && renewalReason == "SpecChanged" && existingCert.CertData != "" {
+			previous := *existingCert
+			superseded = &previous
+		}
+
 		// 复制域名和配置信息到现有证书
 		existingCert.Domains = cert.Domains
 		existingCert.DNSProvider = cert.DNSProvider
 		existingCert.Server = cert.Server
+		existingCert.Email = cert.Email
+		existingCert.Account = cert.Account
+		existingCert.Issuer = cert.Issuer
+		existingCert.Challenge = cert.Challenge
+		existingCert.ChallengeAlias = cert.ChallengeAlias
+		existingCert.DomainAlias = cert.DomainAlias
 		existingCert.Secrets = cert.Secrets
-
-		utils.DebugLog("更新证书配置: 域名=%v, 提供方=%s", existingCert.Domains, existingCert.DNSProvider)
+		existingCert.Envs = cert.Envs
+		existingCert.EnvsFrom = cert.EnvsFrom
+		existingCert.Webhook = cert.Webhook
+		existingCert.EAB = cert.EAB
+		existingCert.PropagationTimeout = cert.PropagationTimeout
+		existingCert.PropagationInterval = cert.PropagationInterval
+
+		utils.DebugLog("更新证书配置: 域名=%v, 提供方=%s, 签发者=%s", existingCert.Domains, existingCert.DNSProvider, existingCert.Issuer)
+		if err := c.certificateService.ResolveEnvs(ctx, existingCert, ConfigSecretNamespace); err != nil {
+			return existingCert, err
+		}
+		if err := c.certificateService.ResolveEAB(ctx, existingCert, ConfigSecretNamespace); err != nil {
+			return existingCert, err
+		}
 
 		utils.InfoLog("尝试续签证书 %s", cert.Name)
-		if err := c.acmeService.RenewCertificate(ctx, existingCert); err != nil {
-			return fmt.Errorf("续签证书失败: %v", err)
+		c.markIssuing(ctx, cert.Name, renewalReason, renewalMessage)
+		if err := issuer.Renew(ctx, existingCert); err != nil {
+			return existingCert, wrapIssueError("续签证书失败", err)
 		}
 
 		// 存储更新后的证书信息
 		utils.DebugLog("存储更新后的证书信息")
 		if err := c.certificateService.StoreCertificate(ctx, existingCert); err != nil {
-			return fmt.Errorf("存储更新的证书信息失败: %v", err)
+			return existingCert, fmt.Errorf("存储更新的证书信息失败: %v", err)
 		}
 
 		// 更新Secret
 		utils.DebugLog("更新Secret中的证书数据")
 		if err := c.certificateService.UpdateSecrets(ctx, existingCert); err != nil {
-			return fmt.Errorf("更新Secret失败: %v", err)
+			return existingCert, fmt.Errorf("更新Secret失败: %v", err)
 		}
 
 		utils.InfoLog("续签并更新证书 %s 成功", cert.Name)
+		if superseded != nil {
+			c.revokeSuperseded(ctx, superseded)
+		}
 	} else {
-		// 确保Secret中的证书是最新的
+		// 确保Secret中的证书是最新的，目标Secret列表以当前配置为准
+		existingCert.Secrets = cert.Secrets
 		utils.DebugLog("确保Secret中的证书是最新的")
 		if err := c.certificateService.UpdateSecrets(ctx, existingCert); err != nil {
-			return fmt.Errorf("更新Secret失败: %v", err)
+			return existingCert, fmt.Errorf("更新Secret失败: %v", err)
 		}
 		utils.DebugLog("Secret包含最新的证书数据")
 	}
 
-	return nil
+	return existingCert, nil
 }
 
-// parseYamlConfig 解析YAML配置为证书对象
-func parseYamlConfig(yamlData []byte) ([]*models.Certificate, error) {
-	var config struct {
-		Domains []models.Certificate `yaml:"domains"`
+// revokeSuperseded 使用签发旧证书的签发者吊销已被替换的证书，失败时只记录日志
+func (c *CertificateController) revokeSuperseded(ctx context.Context, cert *models.Certificate) {
+	issuer, err := c.issuers.Get(cert.Issuer)
+	if err != nil {
+		utils.WarningLog("无法吊销证书 %s 被替换的旧证书: %v", cert.Name, err)
+		return
+	}
+	revoker, ok := issuer.(services.Revoker)
+	if !ok {
+		utils.DebugLog("签发者 %q 不支持吊销，保留证书 %s 被替换的旧证书", cert.Issuer, cert.Name)
+		return
 	}
+	if err := revoker.Revoke(ctx, cert); err != nil {
+		utils.WarningLog("吊销证书 %s 被替换的旧证书失败: %v", cert.Name, err)
+		return
+	}
+	utils.InfoLog("已吊销证书 %s 被替换的旧证书", cert.Name)
+}
 
-	if err := yaml.Unmarshal(yamlData, &config); err != nil {
-		return nil, fmt.Errorf("解析YAML配置失败: %v", err)
+// wrapIssueError 为签发者返回的错误加上说明，配置错误仍保持为*services.ConfigError
+func wrapIssueError(message string, err error) error {
+	var configErr *services.ConfigError
+	if errors.As(err, &configErr) {
+		return &services.ConfigError{Err: fmt.Errorf("%s: %v", message, configErr.Err)}
 	}
+	return fmt.Errorf("%s: %v", message, err)
+}
 
-	var result []*models.Certificate
-	for i := range config.Domains {
-		result = append(result, &config.Domains[i])
+// markIssuing 在开始签发或续签前更新状态
+func (c *CertificateController) markIssuing(ctx context.Context, name, reason, message string) {
+	err := c.statusService.UpdateStatus(ctx, name, func(status *models.CertificateStatus) {
+		status.SetCondition(models.ConditionIssuing, models.ConditionTrue, reason, message)
+	})
+	if err != nil {
+		utils.ErrorLog("更新证书 %s 状态失败: %v", name, err)
 	}
+}
 
-	return result, nil
+// recordStatus 根据处理结果和当前存储的证书更新状态
+func (c *CertificateController) recordStatus(ctx context.Context, name string, stored *models.Certificate, processErr error) {
+	var leaf *x509.Certificate
+	if stored != nil && stored.CertData != "" {
+		leaf, _ = c.certificateService.ParseCertificateData(stored.CertData)
+	}
+
+	// 续签时间可能需要选择并写入证书状态，在更新状态之前确定，冲突重试时mutate不会重复写入
+	var scheduled time.Time
+	if leaf != nil {
+		scheduled, _ = c.renewalTime(ctx, stored, leaf)
+	}
+
+	err := c.statusService.UpdateStatus(ctx, name, func(status *models.CertificateStatus) {
+		status.SetCondition(models.ConditionIssuing, models.ConditionFalse, "Idle", "")
+
+		if leaf != nil {
+			notBefore := metav1.NewTime(leaf.NotBefore)
+			notAfter := metav1.NewTime(leaf.NotAfter)
+			renewalTime := metav1.NewTime(scheduled)
+			status.NotBefore = &notBefore
+			status.NotAfter = &notAfter
+			status.RenewalTime = &renewalTime
+			status.Serial = leaf.SerialNumber.Text(16)
+		}
+
+		// 只有本次处理进行过DNS-01验证时才更新生效情况
+		if stored != nil && stored.Propagation != nil {
+			status.DNSPropagation = stored.Propagation
+		}
+
+		if processErr != nil {
+			now := metav1.Now()
+			status.FailureCount++
+			status.LastError = processErr.Error()
+			status.LastFailureTime = &now
+			reason := "IssueFailed"
+			var configErr *services.ConfigError
+			if errors.As(processErr, &configErr) {
+				reason = "ConfigError"
+			}
+			status.SetCondition(models.ConditionFailed, models.ConditionTrue, reason, processErr.Error())
+		} else {
+			status.FailureCount = 0
+			status.SetCondition(models.ConditionFailed, models.ConditionFalse, "Succeeded", "")
+		}
+
+		switch {
+		case leaf == nil:
+			status.SetCondition(models.ConditionReady, models.ConditionFalse, "NotIssued", "证书尚未签发")
+		case time.Now().After(leaf.NotAfter):
+			status.SetCondition(models.ConditionReady, models.ConditionFalse, "Expired", fmt.Sprintf("证书已于 %s 过期", leaf.NotAfter.Format(time.RFC3339)))
+		default:
+			status.SetCondition(models.ConditionReady, models.ConditionTrue, "Valid", fmt.Sprintf("证书有效期至 %s", leaf.NotAfter.Format(time.RFC3339)))
+		}
+	})
+	if err != nil {
+		utils.ErrorLog("更新证书 %s 状态失败: %v", name, err)
+	}
 }
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/services"
+)
+
+// revokingIssuer 为证书的域名签发自签名证书，并记录被吊销的证书序列号
+type revokingIssuer struct {
+	t       *testing.T
+	revoked []string
+}
+
+func (i *revokingIssuer) Issue(ctx context.Context, cert *models.Certificate) error {
+	cert.CertData, cert.KeyData = issueTestCertificate(i.t, cert.Domains)
+	return nil
+}
+
+func (i *revokingIssuer) Renew(ctx context.Context, cert *models.Certificate) error {
+	return i.Issue(ctx, cert)
+}
+
+func (i *revokingIssuer) Revoke(ctx context.Context, cert *models.Certificate) error {
+	leaf, err := services.NewCertificateService(nil).ParseCertificateData(cert.CertData)
+	if err != nil {
+		return err
+	}
+	i.revoked = append(i.revoked, leaf.SerialNumber.Text(16))
+	return nil
+}
+
+// issueTestCertificate 为域名生成自签名证书，返回Base64编码的证书和私钥PEM
+func issueTestCertificate(t *testing.T, domains []string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// TestProcessCertificateRevokesSuperseded 配置变更导致重新签发后，启用REVOKE_SUPERSEDED时吊销被替换的证书
+func TestProcessCertificateRevokesSuperseded(t *testing.T) {
+	defer func(revoke bool) { RevokeSuperseded = revoke }(RevokeSuperseded)
+
+	tests := []struct {
+		name    string
+		revoke  bool
+		domains []string
+		want    bool
+	}{
+		{"domains changed", true, []string{"example.test", "www.example.test"}, true},
+		{"revocation disabled", false, []string{"example.test", "www.example.test"}, false},
+		{"no drift", true, []string{"example.test"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RevokeSuperseded = tt.revoke
+			ctx := context.Background()
+			clientset := fake.NewSimpleClientset()
+			issuer := &revokingIssuer{t: t}
+			issuers := services.NewIssuerRegistry(clientset, services.NewAcmeService(clientset, nil, nil))
+			issuers.Register("test", issuer)
+			c := NewCertificateController(clientset, nil, services.NewCertificateService(clientset), services.NewStatusService(clientset, nil), issuers)
+
+			previous := &models.Certificate{Name: "example", Issuer: "test", Domains: []string{"example.test"}}
+			if err := issuer.Issue(ctx, previous); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.certificateService.StoreCertificate(ctx, previous); err != nil {
+				t.Fatal(err)
+			}
+			previousLeaf, err := c.certificateService.ParseCertificateData(previous.CertData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			desired := &models.Certificate{
+				Name:    "example",
+				Issuer:  "test",
+				Domains: tt.domains,
+				Secrets: []models.SecretRef{{Namespace: "default", Name: "example-tls"}},
+			}
+			stored, err := c.processCertificate(ctx, desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := c.certificateService.ParseCertificateData(stored.CertData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced := leaf.SerialNumber.Cmp(previousLeaf.SerialNumber) != 0; replaced != (len(tt.domains) > 1) {
+				t.Fatalf("certificate replaced = %v", replaced)
+			}
+
+			if !tt.want {
+				if len(issuer.revoked) != 0 {
+					t.Errorf("revoked %v, want nothing", issuer.revoked)
+				}
+				return
+			}
+			if len(issuer.revoked) != 1 || issuer.revoked[0] != previousLeaf.SerialNumber.Text(16) {
+				t.Errorf("revoked %v, want only the superseded serial %s", issuer.revoked, previousLeaf.SerialNumber.Text(16))
+			}
+		})
+	}
+}
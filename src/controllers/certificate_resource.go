@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/services"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// startResourceWatcher 监听集群中的 Certificate 资源，变更时将其 namespace/name 放入工作队列
+// 集群中没有安装CRD时跳过，仅使用配置Secret
+func (c *CertificateController) startResourceWatcher(ctx context.Context) error {
+	groupVersion := models.CertificateGroup + "/" + models.CertificateVersion
+	if _, err := c.clientset.Discovery().ServerResourcesForGroupVersion(groupVersion); err != nil {
+		utils.WarningLog("集群中没有找到 %s 的 Certificate CRD，跳过资源监听: %v", groupVersion, err)
+		return nil
+	}
+
+	utils.InfoLog("开始监听 Certificate 资源")
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(c.dynamicClient, 0)
+	informer := factory.ForResource(models.CertificateGVR).Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueResource,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// 仅状态变化时generation不变，不需要重新处理
+			oldMeta, oldOk := oldObj.(metav1.Object)
+			newMeta, newOk := newObj.(metav1.Object)
+			if oldOk && newOk && oldMeta.GetGeneration() == newMeta.GetGeneration() {
+				return
+			}
+			c.enqueueResource(newObj)
+		},
+		DeleteFunc: c.enqueueResource,
+	})
+	if err != nil {
+		return fmt.Errorf("注册 Certificate 事件处理失败: %v", err)
+	}
+	c.resourceLister = informer.GetIndexer()
+
+	factory.Start(c.stopCh)
+	if !cache.WaitForCacheSync(c.stopCh, informer.HasSynced) {
+		return fmt.Errorf("等待 Certificate 资源缓存同步失败")
+	}
+
+	return nil
+}
+
+// enqueueResource 将资源的 namespace/name 放入工作队列
+func (c *CertificateController) enqueueResource(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utils.ErrorLog("获取 Certificate 资源键失败: %v", err)
+		return
+	}
+	utils.DebugLog("Certificate 资源 %s 发生变化，加入工作队列", key)
+	c.queue.Add(key)
+}
+
+// syncResource 处理单个 Certificate 资源并回写状态
+func (c *CertificateController) syncResource(ctx context.Context, key string) error {
+	obj, exists, err := c.resourceLister.GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("获取 Certificate 资源失败: %v", err)
+	}
+	if !exists {
+		// 证书资源被删除时保留已签发的证书Secret，避免影响正在使用它的服务
+		utils.InfoLog("Certificate 资源 %s 已删除", key)
+		return nil
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+	var resource models.CertificateObject
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &resource); err != nil {
+		return fmt.Errorf("解析 Certificate 资源失败: %v", err)
+	}
+
+	cert := resource.ToCertificate()
+	if err := c.validateResource(&resource, cert); err != nil {
+		stored, _ := c.certificateService.GetCertificate(ctx, cert.Name)
+		c.recordStatus(ctx, cert.Name, stored, err)
+		return err
+	}
+
+	return c.ProcessCertificate(ctx, cert)
+}
+
+// validateResource 校验证书资源的字段，且只能写入和读取自身命名空间中的Secret、只能调用允许的webhook
+func (c *CertificateController) validateResource(resource *models.CertificateObject, cert *models.Certificate) error {
+	for _, ref := range resource.Spec.Secrets {
+		if ref.Namespace != "" && ref.Namespace != resource.Namespace {
+			return &services.ConfigError{Err: fmt.Errorf("secret %s/%s is outside namespace %s", ref.Namespace, ref.Name, resource.Namespace)}
+		}
+	}
+	for _, ref := range resource.Spec.EnvsFrom {
+		if ref.Namespace != "" && ref.Namespace != resource.Namespace {
+			return &services.ConfigError{Err: fmt.Errorf("envsFrom secret %s/%s is outside namespace %s", ref.Namespace, ref.Name, resource.Namespace)}
+		}
+	}
+
+	if eab := resource.Spec.EAB; eab != nil && eab.Namespace != "" && eab.Namespace != resource.Namespace {
+		return &services.ConfigError{Err: fmt.Errorf("eab secret %s/%s is outside namespace %s", eab.Namespace, eab.Name, resource.Namespace)}
+	}
+
+	// webhook请求带有证书的凭证，资源只能使用运维允许的地址
+	if webhook := resource.Spec.Webhook; cert.DNSProvider == services.DNSProviderWebhook && webhook != nil && !services.WebhookURLAllowed(webhook.URL) {
+		return &services.ConfigError{Err: fmt.Errorf("webhook url %q is not allowed by DNS_WEBHOOK_ALLOWED_URLS", webhook.URL)}
+	}
+
+	issuerType, err := c.issuers.Type(cert.Issuer)
+	if err != nil {
+		return &services.ConfigError{Err: err}
+	}
+	return services.ValidateCertificate(cert, issuerType)
+}
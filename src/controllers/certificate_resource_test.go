@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/services"
+)
+
+// TestValidateResourceWebhook Certificate 资源只能调用DNS_WEBHOOK_ALLOWED_URLS中的webhook
+func TestValidateResourceWebhook(t *testing.T) {
+	defer func(allowed string) { services.DNSWebhookAllowedURLs = allowed }(services.DNSWebhookAllowedURLs)
+	services.DNSWebhookAllowedURLs = "http://dns-webhook.infra.svc:8080/acme"
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(clientset, &ariIssuer{})
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"http://dns-webhook.infra.svc:8080/acme", true},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"https://collector.attacker.test/", false},
+	}
+	for _, tt := range tests {
+		resource := &models.CertificateObject{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "app"},
+			Spec: models.CertificateSpec{
+				Domains:     []string{"app.example.test"},
+				DNSProvider: services.DNSProviderWebhook,
+				Webhook:     &models.WebhookConfig{URL: tt.url},
+				Secrets:     []models.SecretRef{{Name: "app-tls"}},
+			},
+		}
+		err := c.validateResource(resource, resource.ToCertificate())
+		var configErr *services.ConfigError
+		switch {
+		case tt.allowed && err != nil:
+			t.Errorf("webhook %s rejected: %v", tt.url, err)
+		case !tt.allowed && !errors.As(err, &configErr):
+			t.Errorf("webhook %s error = %v, want a ConfigError", tt.url, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/services"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 配置Secret变更后等待的时间，期间的多次修改合并为一次处理
+	ConfigDebounce = getDurationFromEnv("CONFIG_DEBOUNCE", 5*time.Second)
+)
+
+// startConfigWatcher 监听配置Secret，变更时只将新增或修改过的证书放入工作队列
+func (c *CertificateController) startConfigWatcher(ctx context.Context) error {
+	utils.InfoLog("开始监听配置Secret %s/%s", ConfigSecretNamespace, ConfigSecretName)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(c.clientset, 0,
+		informers.WithNamespace(ConfigSecretNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", ConfigSecretName).String()
+		}),
+	)
+	informer := factory.Core().V1().Secrets().Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.scheduleConfigReload(ctx)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.scheduleConfigReload(ctx)
+		},
+		DeleteFunc: func(obj interface{}) {
+			utils.WarningLog("配置Secret %s/%s 已删除，保留现有证书", ConfigSecretNamespace, ConfigSecretName)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("注册配置Secret事件处理失败: %v", err)
+	}
+
+	factory.Start(c.stopCh)
+	if !cache.WaitForCacheSync(c.stopCh, informer.HasSynced) {
+		return fmt.Errorf("等待配置Secret缓存同步失败")
+	}
+	return nil
+}
+
+// scheduleConfigReload 延迟ConfigDebounce后重新加载配置，期间的新变更会重新计时
+func (c *CertificateController) scheduleConfigReload(ctx context.Context) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	if c.configTimer != nil {
+		c.configTimer.Stop()
+	}
+	utils.DebugLog("配置Secret发生变化，%s 后重新加载", ConfigDebounce)
+	c.configTimer = time.AfterFunc(ConfigDebounce, func() {
+		if err := c.reloadConfig(ctx); err != nil {
+			utils.ErrorLog("重新加载证书配置失败，继续使用上一版本的配置: %v", err)
+		}
+	})
+}
+
+// reloadConfig 重新加载配置，与上一版本比较后将新增和修改的证书放入工作队列
+func (c *CertificateController) reloadConfig(ctx context.Context) error {
+	certs, err := c.LoadCertificatesFromConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]*models.Certificate, len(certs))
+	for _, cert := range certs {
+		latest[cert.Name] = cert
+	}
+
+	c.configMu.Lock()
+	previous := c.configCerts
+	c.configCerts = latest
+	c.configMu.Unlock()
+
+	go func() {
+		c.checkAcmeServers(ctx, certs)
+		c.issuers.ACME().Accounts().Reconcile(ctx)
+	}()
+
+	for name, cert := range latest {
+		old, exists := previous[name]
+		switch {
+		case !exists:
+			utils.InfoLog("配置中新增证书 %s", name)
+		case !reflect.DeepEqual(old, cert):
+			utils.InfoLog("配置中的证书 %s 已修改", name)
+		default:
+			continue
+		}
+		c.queue.Add(name)
+	}
+
+	for name := range previous {
+		if _, exists := latest[name]; !exists {
+			// 与 Certificate 资源一致，配置中移除的证书保留已签发的Secret
+			utils.InfoLog("配置中已移除证书 %s", name)
+		}
+	}
+
+	c.requeueConfigErrors()
+
+	return nil
+}
+
+// checkAcmeServers 获取配置中ACME证书所用服务器的目录并输出meta信息，及早发现错误的地址或需要EAB的服务器
+// 每个服务器只在第一次出现时检查
+func (c *CertificateController) checkAcmeServers(ctx context.Context, certs []*models.Certificate) {
+	var servers []string
+	for _, cert := range certs {
+		if issuerType, err := c.issuers.Type(cert.Issuer); err == nil && issuerType == services.IssuerTypeACME && cert.Account == "" {
+			servers = append(servers, cert.Server)
+		}
+	}
+	servers = append(servers, c.issuers.ACME().Accounts().Servers()...)
+	c.issuers.ACME().CheckDirectories(ctx, servers)
+}
+
+// syncConfigCertificate 处理配置Secret中的单个证书
+func (c *CertificateController) syncConfigCertificate(ctx context.Context, name string) error {
+	c.configMu.Lock()
+	cert, exists := c.configCerts[name]
+	c.configMu.Unlock()
+
+	if !exists {
+		utils.DebugLog("证书 %s 已不在配置中，跳过", name)
+		return nil
+	}
+
+	// 使用副本处理，避免签发结果写回配置缓存影响下一次比较
+	certCopy := *cert
+	return c.ProcessCertificate(ctx, &certCopy)
+}
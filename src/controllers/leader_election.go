@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"me.sttot/auto-cert/src/services"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 是否通过Lease选举leader，关闭后每个副本都会处理证书，只适用于单副本部署
+	LeaderElect = getEnvOrDefault("LEADER_ELECT", "true") != "false"
+	// Lease的名称和命名空间
+	LeaderElectionID        = getEnvOrDefault("LEADER_ELECTION_ID", "autocert-leader")
+	LeaderElectionNamespace = getEnvOrDefault("LEADER_ELECTION_NAMESPACE", services.PodNamespace)
+	// leader失联后其他副本等待多久接管、leader续约的最长时间，以及竞选重试的间隔
+	LeaseDuration = getDurationFromEnv("LEADER_ELECTION_LEASE_DURATION", 15*time.Second)
+	RenewDeadline = getDurationFromEnv("LEADER_ELECTION_RENEW_DEADLINE", 10*time.Second)
+	RetryPeriod   = getDurationFromEnv("LEADER_ELECTION_RETRY_PERIOD", 2*time.Second)
+
+	// 指标服务的监听地址，为空时不启动
+	MetricsAddr = getEnvOrDefault("METRICS_ADDR", ":9090")
+)
+
+// LeaderIdentity 返回本副本在Lease中的标识，优先使用Pod名称
+func LeaderIdentity() string {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return name + "_" + string(uuid.NewUUID())
+}
+
+// RunLeaderElection 竞选Lease，成为leader后调用onStartedLeading，失去租约时调用onStoppedLeading
+// ctx结束时主动释放租约，其他副本在一个RetryPeriod内即可接管；函数在退出竞选后返回
+func RunLeaderElection(ctx context.Context, clientset kubernetes.Interface, identity string, onStartedLeading func(ctx context.Context), onStoppedLeading func()) error {
+	var leading atomic.Bool
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      LeaderElectionID,
+			Namespace: LeaderElectionNamespace,
+		},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            LeaderElectionID,
+		LeaseDuration:   LeaseDuration,
+		RenewDeadline:   RenewDeadline,
+		RetryPeriod:     RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				leading.Store(true)
+				utils.InfoLog("%s 成为leader，开始处理证书", identity)
+				onStartedLeading(ctx)
+			},
+			// 没有当选就退出竞选时client-go也会调用该回调，此时忽略
+			OnStoppedLeading: func() {
+				if !leading.Load() {
+					return
+				}
+				utils.InfoLog("%s 不再是leader", identity)
+				onStoppedLeading()
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					utils.InfoLog("当前leader为 %s，本副本 %s 待命", current, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	utils.InfoLog("以 %s 的身份竞选Lease %s/%s", identity, LeaderElectionNamespace, LeaderElectionID)
+	elector.Run(ctx)
+	return nil
+}
+
+// ServeMetrics 在MetricsAddr上提供Prometheus指标，包括 leader_election_master_status
+func ServeMetrics(ctx context.Context) error {
+	if MetricsAddr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              MetricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	utils.InfoLog("指标服务监听 %s", MetricsAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
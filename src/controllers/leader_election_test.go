@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// leaderMetric 返回指标中当前Lease的 leader_election_master_status，没有该指标时返回-1
+func leaderMetric(t *testing.T) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "leader_election_master_status" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "name" && label.GetValue() == LeaderElectionID {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// elector 在后台运行的一个竞选者
+type elector struct {
+	cancel  context.CancelFunc
+	started chan struct{} // 成为leader时关闭
+	stopped chan struct{} // 失去leader时关闭
+	done    chan struct{} // RunLeaderElection返回时关闭
+}
+
+func startElector(t *testing.T, clientset *fake.Clientset, identity string) *elector {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &elector{cancel: cancel, started: make(chan struct{}), stopped: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(e.done)
+		err := RunLeaderElection(ctx, clientset, identity,
+			func(ctx context.Context) { close(e.started) },
+			func() { close(e.stopped) })
+		if err != nil {
+			t.Errorf("RunLeaderElection(%s): %v", identity, err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-e.done
+	})
+	return e
+}
+
+// waitClosed 等待channel关闭，超时后测试失败
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// TestRunLeaderElectionTakeover leader退出时释放租约，待命副本在一个重试间隔内接管，指标随之切换
+func TestRunLeaderElectionTakeover(t *testing.T) {
+	defer func(duration, deadline, period time.Duration) {
+		LeaseDuration, RenewDeadline, RetryPeriod = duration, deadline, period
+	}(LeaseDuration, RenewDeadline, RetryPeriod)
+	LeaseDuration, RenewDeadline, RetryPeriod = 30*time.Second, 20*time.Second, 100*time.Millisecond
+
+	clientset := fake.NewSimpleClientset()
+
+	leader := startElector(t, clientset, "replica-a")
+	waitClosed(t, leader.started, "replica-a to start leading")
+	if got := leaderMetric(t); got != 1 {
+		t.Errorf("leader metric while replica-a leads = %v, want 1", got)
+	}
+
+	// 新的竞选者在进程中将指标重置为待命，之后在租约有效期内不会当选
+	standby := startElector(t, clientset, "replica-b")
+	deadline := time.Now().Add(10 * time.Second)
+	for leaderMetric(t) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("leader metric did not switch to standby")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-standby.started:
+		t.Fatal("replica-b started leading while replica-a holds the lease")
+	case <-time.After(5 * RetryPeriod):
+	}
+
+	// 取消leader后租约被释放，远早于LeaseDuration到期
+	start := time.Now()
+	leader.cancel()
+	waitClosed(t, leader.done, "replica-a to leave the election")
+	waitClosed(t, leader.stopped, "replica-a OnStoppedLeading")
+	waitClosed(t, standby.started, "replica-b to take over")
+	if elapsed := time.Since(start); elapsed >= LeaseDuration {
+		t.Errorf("takeover took %s, want less than the lease duration", elapsed)
+	}
+	if got := leaderMetric(t); got != 1 {
+		t.Errorf("leader metric after takeover = %v, want 1", got)
+	}
+
+	lease, err := clientset.CoordinationV1().Leases(LeaderElectionNamespace).Get(context.Background(), LeaderElectionID, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder == nil || *holder != "replica-b" {
+		t.Errorf("lease holder = %v, want replica-b", holder)
+	}
+	select {
+	case <-standby.stopped:
+		t.Error("replica-b stopped leading")
+	default:
+	}
+}
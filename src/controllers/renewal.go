@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/services"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// renewalTime 返回证书的续签时间，以及下一次需要重新检查的时间
+// 签发者支持ARI时以CA建议的续签窗口为准，CA可能因计划中的批量吊销而提前该窗口；
+// 签发者不支持或查询失败时回退到本地按有效期计算的续签时间
+func (c *CertificateController) renewalTime(ctx context.Context, cert *models.Certificate, leaf *x509.Certificate) (time.Time, time.Time) {
+	local := c.certificateService.RenewalTime(cert, leaf)
+
+	info, err := c.renewalInfo(ctx, cert, leaf)
+	if err != nil {
+		utils.DebugLog("证书 %s 无法获取ARI续签信息，使用本地续签时间: %v", cert.Name, err)
+		return local, local
+	}
+	if info == nil {
+		return local, local
+	}
+
+	renewAt := c.chooseRenewalTime(ctx, cert, info)
+	if info.ExplanationURL != "" && renewAt.Before(local) {
+		utils.InfoLog("CA提前了证书 %s 的续签窗口，说明: %s", cert.Name, info.ExplanationURL)
+	}
+
+	// 续签窗口可能随时变化，到达Retry-After时重新查询
+	recheckAt := renewAt
+	if info.NextPoll.Before(recheckAt) {
+		recheckAt = info.NextPoll
+	}
+	return renewAt, recheckAt
+}
+
+// chooseRenewalTime 返回在ARI窗口内为证书选择的续签时间，新的选择写入证书状态
+// 保存失败时仍使用本次的选择，下次检查时重新选择
+func (c *CertificateController) chooseRenewalTime(ctx context.Context, cert *models.Certificate, info *services.RenewalInfo) time.Time {
+	choice := info.ChooseRenewalTime(cert.ARIRenewal)
+	if choice != cert.ARIRenewal {
+		utils.DebugLog("证书 %s 在续签窗口 %s - %s 内选择 %s 续签", cert.Name,
+			choice.WindowStart.Format(time.RFC3339), choice.WindowEnd.Format(time.RFC3339), choice.RenewAt.Format(time.RFC3339))
+		cert.ARIRenewal = choice
+		if err := c.certificateService.StoreCertificate(ctx, cert); err != nil {
+			utils.WarningLog("保存证书 %s 的续签时间失败: %v", cert.Name, err)
+		}
+	}
+	return choice.RenewAt
+}
+
+// renewalInfo 获取证书的ARI续签信息，在Retry-After之前复用上一次的结果
+// 签发者不支持ARI时返回nil
+func (c *CertificateController) renewalInfo(ctx context.Context, cert *models.Certificate, leaf *x509.Certificate) (*services.RenewalInfo, error) {
+	issuer, err := c.issuers.Get(cert.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := issuer.(services.RenewalInfoProvider)
+	if !ok {
+		return nil, nil
+	}
+
+	serial := leaf.SerialNumber.Text(16)
+	c.renewalInfoMu.Lock()
+	cached, exists := c.renewalInfoCache[cert.Name]
+	c.renewalInfoMu.Unlock()
+	if exists && cached.Serial == serial && time.Now().Before(cached.NextPoll) {
+		return cached, nil
+	}
+
+	info, err := provider.RenewalInfo(ctx, cert, leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	c.renewalInfoMu.Lock()
+	c.renewalInfoCache[cert.Name] = info
+	c.renewalInfoMu.Unlock()
+	return info, nil
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/services"
+)
+
+// ariIssuer 返回固定续签窗口的签发者，记录查询次数
+type ariIssuer struct {
+	info    services.RenewalInfo
+	queries int
+}
+
+func (i *ariIssuer) Issue(ctx context.Context, cert *models.Certificate) error { return nil }
+func (i *ariIssuer) Renew(ctx context.Context, cert *models.Certificate) error { return nil }
+
+func (i *ariIssuer) RenewalInfo(ctx context.Context, cert *models.Certificate, leaf *x509.Certificate) (*services.RenewalInfo, error) {
+	i.queries++
+	info := i.info
+	info.Serial = leaf.SerialNumber.Text(16)
+	return &info, nil
+}
+
+func newTestController(clientset kubernetes.Interface, issuer services.Issuer) *CertificateController {
+	issuers := services.NewIssuerRegistry(clientset, services.NewAcmeService(clientset, nil, nil))
+	issuers.Register("ari", issuer)
+	return NewCertificateController(clientset, nil, services.NewCertificateService(clientset), nil, issuers)
+}
+
+// selfSignedCertificate 生成有效期为90天的自签名证书，返回证书对象和Base64编码的PEM
+func selfSignedCertificate(t *testing.T) (*x509.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		DNSNames:     []string{"example.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf, base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// TestRenewalTimeARI ARI窗口内随机选择的续签时间写入证书状态，重启后保持不变；Retry-After决定下一次检查时间
+func TestRenewalTimeARI(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	windowStart := time.Now().Add(20 * 24 * time.Hour)
+	issuer := &ariIssuer{info: services.RenewalInfo{
+		WindowStart: windowStart,
+		WindowEnd:   windowStart.Add(72 * time.Hour),
+		NextPoll:    time.Now().Add(3 * time.Hour),
+	}}
+
+	leaf, certData := selfSignedCertificate(t)
+	cert := &models.Certificate{Name: "example", Issuer: "ari", Domains: []string{"example.test"}, CertData: certData}
+	first := newTestController(clientset, issuer)
+	if err := first.certificateService.StoreCertificate(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	renewAt, recheckAt := first.renewalTime(ctx, cert, leaf)
+	if renewAt.Before(issuer.info.WindowStart) || renewAt.After(issuer.info.WindowEnd) {
+		t.Fatalf("renewal time %s is outside the window", renewAt)
+	}
+	if !recheckAt.Equal(issuer.info.NextPoll) {
+		t.Errorf("recheck at %s, want the Retry-After time %s", recheckAt, issuer.info.NextPoll)
+	}
+
+	// Retry-After之前复用缓存的续签信息
+	first.renewalTime(ctx, cert, leaf)
+	if issuer.queries != 1 {
+		t.Errorf("renewal info queried %d times before Retry-After, want 1", issuer.queries)
+	}
+
+	// 新的进程从证书状态中读取上次的选择
+	stored, err := first.certificateService.GetCertificate(ctx, cert.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ARIRenewal == nil || !stored.ARIRenewal.RenewAt.Equal(renewAt) {
+		t.Fatalf("stored renewal choice = %+v, want %s", stored.ARIRenewal, renewAt)
+	}
+	second := newTestController(clientset, issuer)
+	if again, _ := second.renewalTime(ctx, stored, leaf); !again.Equal(renewAt) {
+		t.Errorf("renewal time after restart = %s, want %s", again, renewAt)
+	}
+}
+
+// TestRecordStatusChoosesRenewalTimeOnce 状态写入冲突重试时不会重复选择和保存ARI续签时间
+func TestRecordStatusChoosesRenewalTimeOnce(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	windowStart := time.Now().Add(20 * 24 * time.Hour)
+	issuer := &ariIssuer{info: services.RenewalInfo{
+		WindowStart: windowStart,
+		WindowEnd:   windowStart.Add(72 * time.Hour),
+		NextPoll:    time.Now().Add(3 * time.Hour),
+	}}
+	c := newTestController(clientset, issuer)
+	c.statusService = services.NewStatusService(clientset, nil)
+
+	_, certData := selfSignedCertificate(t)
+	cert := &models.Certificate{Name: "example", Issuer: "ari", Domains: []string{"example.test"}, CertData: certData}
+	if err := c.certificateService.StoreCertificate(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// 前两次写入状态ConfigMap时返回冲突
+	conflicts := 0
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts < 2 {
+			conflicts++
+			return true, nil, apierrors.NewAlreadyExists(corev1.Resource("configmaps"), services.StatusConfigMapName)
+		}
+		return false, nil, nil
+	})
+	clientset.ClearActions()
+
+	c.recordStatus(ctx, cert.Name, cert, nil)
+	if conflicts != 2 {
+		t.Fatalf("status write conflicted %d times, want 2", conflicts)
+	}
+
+	// 续签时间在读取状态之前保存且只保存一次，状态的读取和重试中没有其他写入
+	writes, statusRead := 0, false
+	for _, action := range clientset.Actions() {
+		switch {
+		case action.GetResource().Resource == "configmaps":
+			statusRead = true
+		case action.GetVerb() == "create" || action.GetVerb() == "update":
+			writes++
+			if statusRead {
+				t.Errorf("%s %s while updating the status", action.GetVerb(), action.GetResource().Resource)
+			}
+		}
+	}
+	if writes != 1 {
+		t.Errorf("state secret written %d times, want 1", writes)
+	}
+
+	status, err := c.statusService.GetStatus(ctx, cert.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.ARIRenewal == nil || status.RenewalTime == nil || !status.RenewalTime.Time.Equal(cert.ARIRenewal.RenewAt.Truncate(time.Second)) {
+		t.Errorf("status renewal time = %v, want the stored choice %+v", status.RenewalTime, cert.ARIRenewal)
+	}
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
@@ -16,6 +22,11 @@ import (
 )
 
 func main() {
+	// auto-cert validate -f config.yaml 只校验配置文件，不连接集群
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
 	// 初始化日志系统
 	utils.InitLogger()
 
@@ -35,28 +46,87 @@ func main() {
 		log.Fatalf("无法创建 Kubernetes 客户端: %v", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		log.Fatalf("无法创建 Kubernetes 动态客户端: %v", err)
+	}
+
 	utils.DebugLog("成功创建Kubernetes客户端")
 
 	// 初始化服务
-	acmeService := services.NewAcmeService()
+	challenges := services.NewChallengeStore(clientset)
+	http01 := services.NewHTTP01Responder(clientset, challenges)
+	tlsALPN01 := services.NewTLSALPN01Responder(challenges)
+	acmeService := services.NewAcmeService(clientset, http01, tlsALPN01)
+	issuers := services.NewIssuerRegistry(clientset, acmeService)
 	certificateService := services.NewCertificateService(clientset)
+	statusService := services.NewStatusService(clientset, dynamicClient)
 
 	utils.DebugLog("服务初始化完成")
 
 	// 初始化控制器
-	certController := controllers.NewCertificateController(clientset, certificateService, acmeService)
+	certController := controllers.NewCertificateController(clientset, dynamicClient, certificateService, statusService, issuers)
 	utils.DebugLog("控制器初始化完成")
 
 	// 创建上下文
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// 启动控制器
-	utils.DebugLog("正在启动证书控制器...")
-	if err := certController.Start(ctx); err != nil {
-		log.Fatalf("启动证书控制器失败: %v", err)
+	// 监听其他副本布置的验证内容，同步期间验证服务已经开始监听
+	go func() {
+		if err := challenges.Start(ctx); err != nil {
+			utils.ErrorLog("监听验证Secret失败: %v", err)
+		}
+	}()
+
+	// 启动HTTP-01验证服务
+	go func() {
+		if err := http01.ListenAndServe(ctx); err != nil {
+			utils.ErrorLog("HTTP-01验证服务异常退出: %v", err)
+		}
+	}()
+
+	// 启动TLS-ALPN-01验证服务
+	go func() {
+		if err := tlsALPN01.ListenAndServe(ctx); err != nil {
+			utils.ErrorLog("TLS-ALPN-01验证服务异常退出: %v", err)
+		}
+	}()
+
+	// 启动指标服务
+	go func() {
+		if err := controllers.ServeMetrics(ctx); err != nil {
+			utils.ErrorLog("指标服务异常退出: %v", err)
+		}
+	}()
+
+	startController := func(ctx context.Context) {
+		utils.DebugLog("正在启动证书控制器...")
+		if err := certController.Start(ctx); err != nil {
+			log.Fatalf("启动证书控制器失败: %v", err)
+		}
+		utils.DebugLog("证书控制器已成功启动")
+	}
+
+	// 多副本部署时只有持有Lease的leader处理证书，其他副本只响应HTTP-01和TLS-ALPN-01验证
+	electionDone := make(chan struct{})
+	if controllers.LeaderElect {
+		go func() {
+			defer close(electionDone)
+			err := controllers.RunLeaderElection(ctx, clientset, controllers.LeaderIdentity(), startController, func() {
+				if ctx.Err() == nil {
+					// 控制器的工作队列无法重新启动，退出后由Kubernetes重启并重新竞选
+					log.Fatalf("失去leader租约，退出以便重新竞选")
+				}
+			})
+			if err != nil {
+				log.Fatalf("启动leader选举失败: %v", err)
+			}
+		}()
+	} else {
+		close(electionDone)
+		startController(ctx)
 	}
-	utils.DebugLog("证书控制器已成功启动")
 
 	// 等待信号以优雅退出
 	sigCh := make(chan os.Signal, 1)
@@ -66,5 +136,50 @@ func main() {
 	utils.InfoLog("收到退出信号，正在停止服务...")
 	certController.Stop()
 	utils.DebugLog("控制器已停止")
+
+	// 释放租约，让其他副本立即接管
+	cancel()
+	select {
+	case <-electionDone:
+	case <-time.After(5 * time.Second):
+		utils.WarningLog("等待释放leader租约超时")
+	}
 	utils.InfoLog("服务已停止")
 }
+
+// runValidate 校验配置文件并逐条打印错误，配置有效时返回0
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	file := fs.String("f", "config.yaml", "要校验的配置文件，- 表示从标准输入读取")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取配置文件失败: %v\n", err)
+		return 2
+	}
+
+	config, err := services.ParseConfig(data)
+	if err != nil {
+		var errs services.ValidationErrors
+		if errors.As(err, &errs) {
+			for _, fe := range errs {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", *file, fe)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *file, err)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s: 配置有效，共 %d 个证书和 %d 个签发者\n", *file, len(config.Domains), len(config.Issuers))
+	return 0
+}
@@ -1,25 +1,101 @@
 package models
 
+import "time"
+
 type SecretRef struct {
 	Namespace string `json:"namespace" yaml:"namespace"`
 	Name      string `json:"name" yaml:"name"`
 }
 
+// WebhookConfig dns: webhook 时调用的外部DNS服务
+type WebhookConfig struct {
+	URL     string `json:"url" yaml:"url"`
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"` // 单次请求超时，默认30s
+}
+
+// EnvFromSource 从Secret读取DNS提供商的环境变量，key为空时导入Secret中的所有键
+type EnvFromSource struct {
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Name      string `json:"name" yaml:"name"`
+	Key       string `json:"key,omitempty" yaml:"key,omitempty"`
+	Env       string `json:"env,omitempty" yaml:"env,omitempty"` // 环境变量名，默认与key相同
+}
+
+// EABConfig ACME外部账户绑定(EAB)凭证所在的Secret
+type EABConfig struct {
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Name      string `json:"name" yaml:"name"`
+	KeyIDKey  string `json:"kidKey,omitempty" yaml:"kidKey,omitempty"`   // 保存key ID的键，默认 kid
+	HMACKey   string `json:"hmacKey,omitempty" yaml:"hmacKey,omitempty"` // 保存base64url编码HMAC密钥的键，默认 hmac
+}
+
+// EABCredentials 从Secret读取的EAB凭证
+type EABCredentials struct {
+	KeyID   string
+	HMACKey []byte
+}
+
 type Certificate struct {
-	Name        string            `json:"name" yaml:"name"`
-	Domains     []string          `json:"domains" yaml:"domains"`
-	DNSProvider string            `json:"dns" yaml:"dns"`
-	Server      string            `json:"server" yaml:"server"`
-	Email       string            `json:"email" yaml:"email"`
-	Secrets     []SecretRef       `json:"secrets" yaml:"secrets"`
-	Envs        map[string]string `json:"envs,omitempty" yaml:"envs,omitempty"`
-	IssuedAt    string            `json:"issued_at,omitempty" yaml:"issued_at,omitempty"`
-	ExpiresAt   string            `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
-	CertData    string            `json:"cert_data,omitempty" yaml:"cert_data,omitempty"` // Base64 encoded certificate data
-	KeyData     string            `json:"key_data,omitempty" yaml:"key_data,omitempty"`   // Base64 encoded key data
-}
-
-// CertificateContext 用于持久化存储证书信息
+	Name                  string            `json:"name" yaml:"name"`
+	Domains               []string          `json:"domains" yaml:"domains"`
+	DNSProvider           string            `json:"dns" yaml:"dns"`
+	Server                string            `json:"server" yaml:"server"`
+	Email                 string            `json:"email" yaml:"email"`
+	Account               string            `json:"account,omitempty" yaml:"account,omitempty"`               // 具名ACME账户，设置后使用账户的服务器、邮箱和EAB
+	Issuer                string            `json:"issuer,omitempty" yaml:"issuer,omitempty"`                 // 签发者名称，为空时使用acme
+	Challenge             string            `json:"challenge,omitempty" yaml:"challenge,omitempty"`           // ACME验证方式: dns-01（默认）、http-01 或 tls-alpn-01
+	ChallengeAlias        map[string]string `json:"challengeAlias,omitempty" yaml:"challengeAlias,omitempty"` // 域名到别名域的映射，TXT记录写入 _acme-challenge.<别名>
+	DomainAlias           map[string]string `json:"domainAlias,omitempty" yaml:"domainAlias,omitempty"`       // 域名到别名记录的映射，TXT记录直接写入<别名>
+	Secrets               []SecretRef       `json:"secrets" yaml:"secrets"`
+	Envs                  map[string]string `json:"envs,omitempty" yaml:"envs,omitempty"`
+	EnvsFrom              []EnvFromSource   `json:"envsFrom,omitempty" yaml:"envsFrom,omitempty"`                           // 签发时从Secret读取的环境变量
+	Webhook               *WebhookConfig    `json:"webhook,omitempty" yaml:"webhook,omitempty"`                             // dns为webhook时的webhook配置
+	EAB                   *EABConfig        `json:"eab,omitempty" yaml:"eab,omitempty"`                                     // 注册ACME账户时使用的外部账户绑定
+	RenewBefore           string            `json:"renewBefore,omitempty" yaml:"renewBefore,omitempty"`                     // 过期前多久续签，例如 720h 或 30d，为空时使用全局默认值
+	PropagationTimeout    string            `json:"propagationTimeout,omitempty" yaml:"propagationTimeout,omitempty"`       // 等待验证记录在权威服务器上生效的最长时间，默认使用 DNS_PROPAGATION_TIMEOUT
+	PropagationInterval   string            `json:"propagationInterval,omitempty" yaml:"propagationInterval,omitempty"`     // 检查权威服务器的间隔，默认使用 DNS_PROPAGATION_INTERVAL
+	RenewBeforePercentage int               `json:"renewBeforePercentage,omitempty" yaml:"renewBeforePercentage,omitempty"` // 剩余有效期低于总有效期的该百分比时续签（1-99），优先于renewBefore
+	IssuedAt              string            `json:"issued_at,omitempty" yaml:"issued_at,omitempty"`
+	ExpiresAt             string            `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	CertData              string            `json:"cert_data,omitempty" yaml:"cert_data,omitempty"` // Base64 encoded certificate data
+	KeyData               string            `json:"key_data,omitempty" yaml:"key_data,omitempty"`   // Base64 encoded key data
+
+	// 在CA通过ARI建议的续签窗口内随机选择的续签时间，随证书状态保存，重启或切换leader后保持不变
+	ARIRenewal *ARIRenewal `json:"ari_renewal,omitempty" yaml:"-"`
+
+	// 最近一次签发中验证记录在各权威服务器上的生效情况，只用于状态展示，不持久化
+	Propagation []NameserverPropagation `json:"-" yaml:"-"`
+	// 签发时从envsFrom读取的环境变量，不持久化
+	ResolvedEnvs map[string]string `json:"-" yaml:"-"`
+	// 签发时从eab引用的Secret读取的凭证，不持久化
+	ResolvedEAB *EABCredentials `json:"-" yaml:"-"`
+}
+
+// ARIRenewal 为某个证书序列号在建议窗口内选择的续签时间，序列号或窗口变化后重新选择
+type ARIRenewal struct {
+	Serial      string    `json:"serial"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	RenewAt     time.Time `json:"renew_at"`
+}
+
+// DNSEnvs 返回传给DNS提供商的环境变量，envs中的同名变量优先于envsFrom
+func (c *Certificate) DNSEnvs() map[string]string {
+	if len(c.ResolvedEnvs) == 0 {
+		return c.Envs
+	}
+
+	envs := make(map[string]string, len(c.ResolvedEnvs)+len(c.Envs))
+	for k, v := range c.ResolvedEnvs {
+		envs[k] = v
+	}
+	for k, v := range c.Envs {
+		envs[k] = v
+	}
+	return envs
+}
+
+// CertificateContext 证书名称到证书信息的映射，也是旧版本上下文Secret中context键的格式
 type CertificateContext struct {
 	Certificates map[string]Certificate `json:"certificates" yaml:"certificates"`
 }
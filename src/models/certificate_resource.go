@@ -0,0 +1,101 @@
+package models
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Certificate CRD 的 API 信息
+const (
+	CertificateGroup    = "autocert.sttot.me"
+	CertificateVersion  = "v1alpha1"
+	CertificateKind     = "Certificate"
+	CertificateResource = "certificates"
+)
+
+// CertificateGVR Certificate CRD 的 GroupVersionResource
+var CertificateGVR = schema.GroupVersionResource{
+	Group:    CertificateGroup,
+	Version:  CertificateVersion,
+	Resource: CertificateResource,
+}
+
+// CertificateObject 命名空间级的 Certificate 自定义资源
+type CertificateObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CertificateSpec   `json:"spec"`
+	Status CertificateStatus `json:"status,omitempty"`
+}
+
+// CertificateSpec 与配置文件中的证书条目字段一致
+type CertificateSpec struct {
+	Domains     []string          `json:"domains"`
+	DNSProvider string            `json:"dns,omitempty"`
+	Server      string            `json:"server,omitempty"`
+	Email       string            `json:"email,omitempty"`
+	Account     string            `json:"account,omitempty"`
+	Issuer      string            `json:"issuer,omitempty"`
+	Challenge   string            `json:"challenge,omitempty"`
+	Secrets     []SecretRef       `json:"secrets"`
+	Envs        map[string]string `json:"envs,omitempty"`
+	EnvsFrom    []EnvFromSource   `json:"envsFrom,omitempty"`
+	Webhook     *WebhookConfig    `json:"webhook,omitempty"`
+	EAB         *EABConfig        `json:"eab,omitempty"`
+
+	ChallengeAlias map[string]string `json:"challengeAlias,omitempty"`
+	DomainAlias    map[string]string `json:"domainAlias,omitempty"`
+
+	RenewBefore           string `json:"renewBefore,omitempty"`
+	RenewBeforePercentage int    `json:"renewBeforePercentage,omitempty"`
+
+	PropagationTimeout  string `json:"propagationTimeout,omitempty"`
+	PropagationInterval string `json:"propagationInterval,omitempty"`
+}
+
+// ToCertificate 转换为控制器内部使用的证书对象
+// 名称使用 namespace/name，以免与配置文件中的证书冲突；未指定命名空间的Secret默认与资源在同一命名空间
+func (o *CertificateObject) ToCertificate() *Certificate {
+	cert := &Certificate{
+		Name:        o.Namespace + "/" + o.Name,
+		Domains:     o.Spec.Domains,
+		DNSProvider: o.Spec.DNSProvider,
+		Server:      o.Spec.Server,
+		Email:       o.Spec.Email,
+		Account:     o.Spec.Account,
+		Issuer:      o.Spec.Issuer,
+		Challenge:   o.Spec.Challenge,
+		Envs:        o.Spec.Envs,
+		Webhook:     o.Spec.Webhook,
+
+		ChallengeAlias: o.Spec.ChallengeAlias,
+		DomainAlias:    o.Spec.DomainAlias,
+
+		RenewBefore:           o.Spec.RenewBefore,
+		RenewBeforePercentage: o.Spec.RenewBeforePercentage,
+
+		PropagationTimeout:  o.Spec.PropagationTimeout,
+		PropagationInterval: o.Spec.PropagationInterval,
+	}
+	for _, ref := range o.Spec.EnvsFrom {
+		if ref.Namespace == "" {
+			ref.Namespace = o.Namespace
+		}
+		cert.EnvsFrom = append(cert.EnvsFrom, ref)
+	}
+	for _, ref := range o.Spec.Secrets {
+		if ref.Namespace == "" {
+			ref.Namespace = o.Namespace
+		}
+		cert.Secrets = append(cert.Secrets, ref)
+	}
+	if o.Spec.EAB != nil {
+		eab := *o.Spec.EAB
+		if eab.Namespace == "" {
+			eab.Namespace = o.Namespace
+		}
+		cert.EAB = &eab
+	}
+	return cert
+}
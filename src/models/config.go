@@ -0,0 +1,37 @@
+package models
+
+// Config 证书配置文件的完整结构
+type Config struct {
+	Issuers  []IssuerConfig  `json:"issuers,omitempty" yaml:"issuers,omitempty"`
+	Accounts []AccountConfig `json:"accounts,omitempty" yaml:"accounts,omitempty"`
+	Domains  []Certificate   `json:"domains" yaml:"domains"`
+}
+
+// AccountConfig 具名ACME账户，证书通过account字段按名称引用，账户密钥保存在Secret中
+type AccountConfig struct {
+	Name     string     `json:"name" yaml:"name"`
+	Server   string     `json:"server,omitempty" yaml:"server,omitempty"` // 目录地址或简称，为空时使用默认服务器
+	Email    string     `json:"email,omitempty" yaml:"email,omitempty"`
+	Contacts []string   `json:"contacts,omitempty" yaml:"contacts,omitempty"` // 额外的联系方式，如 mailto:ops@example.com
+	KeyType  string     `json:"keyType,omitempty" yaml:"keyType,omitempty"`   // ec256（默认）、ec384、rsa2048 或 rsa4096
+	EAB      *EABConfig `json:"eab,omitempty" yaml:"eab,omitempty"`
+	// 增大该值时轮换账户密钥，修改keyType也会轮换
+	KeyRevision int `json:"keyRevision,omitempty" yaml:"keyRevision,omitempty"`
+	// 为true时在CA注销该账户，注销不可恢复
+	Deactivated bool `json:"deactivated,omitempty" yaml:"deactivated,omitempty"`
+}
+
+// IssuerConfig 签发者配置，证书通过issuer字段按名称引用
+type IssuerConfig struct {
+	Name string          `json:"name" yaml:"name"`
+	Type string          `json:"type" yaml:"type"` // acme 或 ca
+	CA   *CAIssuerConfig `json:"ca,omitempty" yaml:"ca,omitempty"`
+}
+
+// CAIssuerConfig 使用自有CA签发证书的配置
+type CAIssuerConfig struct {
+	// 保存CA证书(tls.crt)和私钥(tls.key)的Secret
+	Secret SecretRef `json:"secret" yaml:"secret"`
+	// 签发证书的有效期，例如 2160h
+	Duration string `json:"duration,omitempty" yaml:"duration,omitempty"`
+}
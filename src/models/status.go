@@ -0,0 +1,84 @@
+package models
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 证书状态条件类型
+const (
+	ConditionReady   = "Ready"   // Secret中有可用的有效证书
+	ConditionIssuing = "Issuing" // 正在签发或续签
+	ConditionFailed  = "Failed"  // 最近一次签发或续签失败
+)
+
+// 条件状态取值
+const (
+	ConditionTrue  = "True"
+	ConditionFalse = "False"
+)
+
+// CertificateCondition 证书状态条件
+type CertificateCondition struct {
+	Type               string      `json:"type" yaml:"type"`
+	Status             string      `json:"status" yaml:"status"`
+	Reason             string      `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Message            string      `json:"message,omitempty" yaml:"message,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty" yaml:"lastTransitionTime,omitempty"`
+}
+
+// CertificateStatus 证书的签发状态，CRD通过status子资源展示，配置Secret中的证书写入状态ConfigMap
+type CertificateStatus struct {
+	ObservedGeneration int64                   `json:"observedGeneration,omitempty" yaml:"observedGeneration,omitempty"`
+	Conditions         []CertificateCondition  `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	NotBefore          *metav1.Time            `json:"notBefore,omitempty" yaml:"notBefore,omitempty"`
+	NotAfter           *metav1.Time            `json:"notAfter,omitempty" yaml:"notAfter,omitempty"`
+	Serial             string                  `json:"serial,omitempty" yaml:"serial,omitempty"`
+	RenewalTime        *metav1.Time            `json:"renewalTime,omitempty" yaml:"renewalTime,omitempty"`
+	FailureCount       int                     `json:"failureCount,omitempty" yaml:"failureCount,omitempty"`
+	LastError          string                  `json:"lastError,omitempty" yaml:"lastError,omitempty"`
+	LastFailureTime    *metav1.Time            `json:"lastFailureTime,omitempty" yaml:"lastFailureTime,omitempty"`
+	DNSPropagation     []NameserverPropagation `json:"dnsPropagation,omitempty" yaml:"dnsPropagation,omitempty"`
+}
+
+// NameserverPropagation 最近一次DNS-01验证中，一条验证记录在一台权威服务器上的生效情况
+type NameserverPropagation struct {
+	FQDN       string `json:"fqdn" yaml:"fqdn"`
+	Nameserver string `json:"nameserver" yaml:"nameserver"`
+	Ready      bool   `json:"ready" yaml:"ready"`
+	Duration   string `json:"duration,omitempty" yaml:"duration,omitempty"` // 从开始检查到生效（或超时）的时间
+}
+
+// SetCondition 设置条件，状态发生变化时才更新LastTransitionTime
+func (s *CertificateStatus) SetCondition(condType, status, reason, message string) {
+	for i := range s.Conditions {
+		cond := &s.Conditions[i]
+		if cond.Type != condType {
+			continue
+		}
+		if cond.Status != status {
+			cond.LastTransitionTime = metav1.Now()
+		}
+		cond.Status = status
+		cond.Reason = reason
+		cond.Message = message
+		return
+	}
+
+	s.Conditions = append(s.Conditions, CertificateCondition{
+		Type:               condType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	})
+}
+
+// GetCondition 获取指定类型的条件，不存在时返回nil
+func (s *CertificateStatus) GetCondition(condType string) *CertificateCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == condType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
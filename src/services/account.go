@@ -0,0 +1,610 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 具名ACME账户Secret的名称前缀，Secret位于 CONTEXT_SECRET_NAMESPACE
+	AccountSecretPrefix = getEnvOrDefault("ACCOUNT_SECRET_PREFIX", "autocert-account-")
+)
+
+// 账户密钥类型
+const (
+	AccountKeyEC256   = "ec256"
+	AccountKeyEC384   = "ec384"
+	AccountKeyRSA2048 = "rsa2048"
+	AccountKeyRSA4096 = "rsa4096"
+)
+
+// 账户Secret中的键和注解
+const (
+	accountKeyData     = "account.key"
+	accountNextKeyData = "account.next.key" // 轮换中的新密钥，轮换完成后替换account.key
+
+	accountAnnotationServer      = "autocert.sttot.me/server"
+	accountAnnotationURL         = "autocert.sttot.me/account-url"
+	accountAnnotationKeyType     = "autocert.sttot.me/key-type"
+	accountAnnotationKeyRevision = "autocert.sttot.me/key-revision"
+	accountAnnotationContacts    = "autocert.sttot.me/contacts"
+	accountAnnotationStatus      = "autocert.sttot.me/status"
+
+	accountStatusValid       = "valid"
+	accountStatusDeactivated = "deactivated"
+
+	// 未指定具名账户的证书按服务器和邮箱使用的账户，Secret名称为前缀加上该保留前缀和哈希
+	implicitAccountPrefix = "implicit-"
+)
+
+// AccountManager 管理配置中的具名ACME账户：注册、更新联系方式、轮换密钥和注销
+// 账户密钥保存在Secret中，不依赖持久卷
+type AccountManager struct {
+	clientset  kubernetes.Interface
+	httpClient *http.Client
+	acme       *AcmeService
+
+	// mu只保护以下字段，不在网络请求期间持有；同一账户的对齐由locks中该账户的锁串行
+	mu           sync.Mutex
+	configs      map[string]models.AccountConfig
+	eabNamespace string                  // eab未指定命名空间时使用
+	clients      map[string]*acme.Client // 已与配置对齐的账户
+	locks        map[string]*sync.Mutex
+}
+
+func newAccountManager(clientset kubernetes.Interface, httpClient *http.Client, acmeService *AcmeService) *AccountManager {
+	return &AccountManager{
+		clientset:  clientset,
+		httpClient: httpClient,
+		acme:       acmeService,
+		configs:    make(map[string]models.AccountConfig),
+		clients:    make(map[string]*acme.Client),
+		locks:      make(map[string]*sync.Mutex),
+	}
+}
+
+// Configure 根据配置文件中的accounts更新账户定义，配置发生变化的账户在下次使用时重新对齐
+func (m *AccountManager) Configure(configs []models.AccountConfig, eabNamespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest := make(map[string]models.AccountConfig, len(configs))
+	for _, cfg := range configs {
+		latest[cfg.Name] = cfg
+		if old, ok := m.configs[cfg.Name]; !ok || !reflect.DeepEqual(old, cfg) {
+			delete(m.clients, cfg.Name)
+		}
+	}
+	for name := range m.clients {
+		if _, ok := latest[name]; !ok {
+			delete(m.clients, name)
+		}
+	}
+	m.configs = latest
+	m.eabNamespace = eabNamespace
+}
+
+// Server 返回账户所在的ACME服务器
+func (m *AccountManager) Server(name string) (string, error) {
+	m.mu.Lock()
+	cfg, ok := m.configs[name]
+	m.mu.Unlock()
+	if !ok {
+		return "", &ConfigError{Err: fmt.Errorf("未定义的ACME账户 %q", name)}
+	}
+	return ResolveAcmeServer(cfg.Server)
+}
+
+// Servers 返回所有账户使用的ACME服务器
+func (m *AccountManager) Servers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var servers []string
+	for _, cfg := range m.configs {
+		servers = append(servers, cfg.Server)
+	}
+	return servers
+}
+
+// Reconcile 将所有账户与配置对齐，失败的账户只记录日志
+func (m *AccountManager) Reconcile(ctx context.Context) {
+	m.mu.Lock()
+	var names []string
+	deactivated := make(map[string]bool)
+	for name, cfg := range m.configs {
+		names = append(names, name)
+		deactivated[name] = cfg.Deactivated
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, err := m.Client(ctx, name)
+		var configErr *ConfigError
+		switch {
+		case err == nil:
+		case deactivated[name] && errors.As(err, &configErr):
+			// 已按配置注销的账户不再可用，属于预期结果
+			utils.DebugLog("ACME账户 %s 已注销", name)
+		default:
+			utils.ErrorLog("ACME账户 %s 处理失败: %v", name, err)
+		}
+	}
+}
+
+// Client 返回具名账户的ACME客户端，第一次使用或配置变化后先与配置对齐
+func (m *AccountManager) Client(ctx context.Context, name string) (*acme.Client, error) {
+	lock := m.accountLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	m.mu.Lock()
+	client, cached := m.clients[name]
+	cfg, ok := m.configs[name]
+	m.mu.Unlock()
+	if cached {
+		return client, nil
+	}
+	if !ok {
+		return nil, &ConfigError{Err: fmt.Errorf("未定义的ACME账户 %q", name)}
+	}
+
+	client, err := m.reconcile(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// 对齐期间配置可能已经变化，此时不缓存，下次使用时按新配置重新对齐
+	m.mu.Lock()
+	if current, ok := m.configs[name]; ok && reflect.DeepEqual(current, cfg) {
+		m.clients[name] = client
+	}
+	m.mu.Unlock()
+	return client, nil
+}
+
+// accountLock 返回账户对应的锁，不存在时创建
+func (m *AccountManager) accountLock(name string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.locks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[name] = lock
+	}
+	return lock
+}
+
+// reconcile 读取账户Secret，按需注册、更新联系方式、轮换密钥或注销
+func (m *AccountManager) reconcile(ctx context.Context, cfg models.AccountConfig) (*acme.Client, error) {
+	server, err := ResolveAcmeServer(cfg.Server)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	keyType := cfg.KeyType
+	if keyType == "" {
+		keyType = AccountKeyEC256
+	}
+	contacts := accountContacts(cfg)
+	secretName := AccountSecretPrefix + cfg.Name
+
+	secret, err := m.clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if cfg.Deactivated {
+			return nil, &ConfigError{Err: fmt.Errorf("ACME账户 %s 已标记为注销", cfg.Name)}
+		}
+		return m.register(ctx, cfg, server, keyType, contacts, nil)
+	} else if err != nil {
+		return nil, fmt.Errorf("读取账户Secret %s/%s 失败: %v", ContextSecretNamespace, secretName, err)
+	}
+
+	annotations := secret.Annotations
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if stored := annotations[accountAnnotationServer]; stored != "" && stored != server {
+		return nil, &ConfigError{Err: fmt.Errorf("ACME账户 %s 注册在 %s，不能改为 %s，请使用新的账户名", cfg.Name, stored, server)}
+	}
+	if annotations[accountAnnotationStatus] == accountStatusDeactivated {
+		return nil, &ConfigError{Err: fmt.Errorf("ACME账户 %s 已注销", cfg.Name)}
+	}
+	if annotations[accountAnnotationURL] == "" {
+		// 密钥已保存但上次注册没有完成
+		if cfg.Deactivated {
+			return nil, &ConfigError{Err: fmt.Errorf("ACME账户 %s 已标记为注销", cfg.Name)}
+		}
+		secret.Annotations = annotations
+		return m.register(ctx, cfg, server, keyType, contacts, secret)
+	}
+
+	key, err := parseAccountKey(secret.Data[accountKeyData])
+	if err != nil {
+		return nil, fmt.Errorf("解析账户 %s 的密钥失败: %v", cfg.Name, err)
+	}
+	client := m.newClient(server, key)
+	client.KID = acme.KeyID(annotations[accountAnnotationURL])
+
+	changed := false
+	// 上次轮换在保存新密钥后中断时，根据CA上生效的密钥完成或放弃轮换
+	if next := secret.Data[accountNextKeyData]; len(next) > 0 {
+		if err := m.resumeRollover(ctx, cfg.Name, client, secret, next); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+
+	if cfg.Deactivated {
+		utils.InfoLog("注销ACME账户 %s", cfg.Name)
+		if err := client.DeactivateReg(ctx); err != nil {
+			return nil, fmt.Errorf("注销ACME账户 %s 失败: %v", cfg.Name, err)
+		}
+		annotations[accountAnnotationStatus] = accountStatusDeactivated
+		secret.Annotations = annotations
+		if err := m.saveSecret(ctx, secret); err != nil {
+			return nil, err
+		}
+		return nil, &ConfigError{Err: fmt.Errorf("ACME账户 %s 已注销", cfg.Name)}
+	}
+
+	if joined := strings.Join(contacts, ","); joined != annotations[accountAnnotationContacts] {
+		utils.InfoLog("更新ACME账户 %s 的联系方式: %v", cfg.Name, contacts)
+		if _, err := client.UpdateReg(ctx, &acme.Account{Contact: contacts}); err != nil {
+			return nil, fmt.Errorf("更新ACME账户 %s 的联系方式失败: %v", cfg.Name, err)
+		}
+		annotations[accountAnnotationContacts] = joined
+		changed = true
+	}
+
+	revision, _ := strconv.Atoi(annotations[accountAnnotationKeyRevision])
+	if keyType != annotations[accountAnnotationKeyType] || cfg.KeyRevision > revision {
+		if err := m.rollover(ctx, cfg.Name, client, secret, keyType); err != nil {
+			return nil, err
+		}
+		annotations[accountAnnotationKeyType] = keyType
+		annotations[accountAnnotationKeyRevision] = strconv.Itoa(cfg.KeyRevision)
+		changed = true
+	}
+
+	if changed {
+		secret.Annotations = annotations
+		if err := m.saveSecret(ctx, secret); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// register 在CA注册账户。密钥先保存到账户Secret再注册，注册中断后下次使用同一密钥重试
+func (m *AccountManager) register(ctx context.Context, cfg models.AccountConfig, server, keyType string, contacts []string, secret *corev1.Secret) (*acme.Client, error) {
+	if secret == nil {
+		key, err := generateAccountKey(keyType)
+		if err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+		keyPEM, err := encodeAccountKey(key)
+		if err != nil {
+			return nil, err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      AccountSecretPrefix + cfg.Name,
+				Namespace: ContextSecretNamespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/managed-by": "autocert",
+					"autocert.sttot.me/account":    cfg.Name,
+				},
+				Annotations: map[string]string{
+					accountAnnotationServer:      server,
+					accountAnnotationKeyType:     keyType,
+					accountAnnotationKeyRevision: strconv.Itoa(cfg.KeyRevision),
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{accountKeyData: keyPEM},
+		}
+		created, err := m.clientset.CoreV1().Secrets(ContextSecretNamespace).Create(ctx, secret, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("保存账户Secret %s/%s 失败: %v", ContextSecretNamespace, secret.Name, err)
+		}
+		secret = created
+	}
+
+	key, err := parseAccountKey(secret.Data[accountKeyData])
+	if err != nil {
+		return nil, fmt.Errorf("解析账户 %s 的密钥失败: %v", cfg.Name, err)
+	}
+	client := m.newClient(server, key)
+
+	account := &acme.Account{Contact: contacts}
+	if cfg.EAB != nil {
+		m.mu.Lock()
+		eabNamespace := m.eabNamespace
+		m.mu.Unlock()
+		creds, err := readEAB(ctx, m.clientset, "账户 "+cfg.Name, cfg.EAB, eabNamespace)
+		if err != nil {
+			return nil, err
+		}
+		account.ExternalAccountBinding = &acme.ExternalAccountBinding{KID: creds.KeyID, Key: creds.HMACKey}
+	} else if dir, err := m.acme.Directory(ctx, server); err == nil && dir.ExternalAccountRequired {
+		return nil, &ConfigError{Err: fmt.Errorf("ACME服务器 %s 要求外部账户绑定，请为账户 %s 配置eab", server, cfg.Name)}
+	}
+
+	utils.InfoLog("在 %s 注册ACME账户 %s", server, cfg.Name)
+	registered, err := client.Register(ctx, account, acme.AcceptTOS)
+	if errors.Is(err, acme.ErrAccountAlreadyExists) {
+		registered, err = client.GetReg(ctx, "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("注册ACME账户 %s 失败: %v", cfg.Name, err)
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[accountAnnotationURL] = registered.URI
+	secret.Annotations[accountAnnotationContacts] = strings.Join(contacts, ",")
+	secret.Annotations[accountAnnotationStatus] = accountStatusValid
+	if err := m.saveSecret(ctx, secret); err != nil {
+		return nil, err
+	}
+
+	utils.InfoLog("ACME账户 %s 已注册: %s", cfg.Name, registered.URI)
+	return client, nil
+}
+
+// rollover 轮换账户密钥：先把新密钥保存到Secret，CA确认后再替换旧密钥，避免中断时丢失新密钥
+func (m *AccountManager) rollover(ctx context.Context, name string, client *acme.Client, secret *corev1.Secret, keyType string) error {
+	newKey, err := generateAccountKey(keyType)
+	if err != nil {
+		return &ConfigError{Err: err}
+	}
+	keyPEM, err := encodeAccountKey(newKey)
+	if err != nil {
+		return err
+	}
+
+	secret.Data[accountNextKeyData] = keyPEM
+	if err := m.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+
+	utils.InfoLog("轮换ACME账户 %s 的密钥，新密钥类型: %s", name, keyType)
+	if err := client.AccountKeyRollover(ctx, newKey); err != nil {
+		return fmt.Errorf("轮换ACME账户 %s 的密钥失败: %v", name, err)
+	}
+
+	client.Key = newKey
+	secret.Data[accountKeyData] = keyPEM
+	delete(secret.Data, accountNextKeyData)
+	return nil
+}
+
+// resumeRollover 处理中断的密钥轮换：旧密钥仍能查到账户说明轮换没有生效，否则改用新密钥
+func (m *AccountManager) resumeRollover(ctx context.Context, name string, client *acme.Client, secret *corev1.Secret, next []byte) error {
+	_, err := client.GetReg(ctx, "")
+	switch {
+	case err == nil:
+		utils.WarningLog("ACME账户 %s 上次的密钥轮换没有完成，放弃未生效的新密钥", name)
+	case errors.Is(err, acme.ErrNoAccount):
+		newKey, perr := parseAccountKey(next)
+		if perr != nil {
+			return fmt.Errorf("解析账户 %s 的新密钥失败: %v", name, perr)
+		}
+		utils.InfoLog("ACME账户 %s 的密钥轮换已在CA生效，改用新密钥", name)
+		client.Key = newKey
+		secret.Data[accountKeyData] = next
+	default:
+		return fmt.Errorf("查询ACME账户 %s 失败: %v", name, err)
+	}
+	delete(secret.Data, accountNextKeyData)
+	return nil
+}
+
+// ImplicitKey 返回未指定具名账户的证书在server上以email注册使用的账户密钥
+// 密钥保存在Secret中；Secret不存在时先导入旧版本保存在 ACME_STATE_DIR 下的密钥，否则生成新密钥
+func (m *AccountManager) ImplicitKey(ctx context.Context, server, email string) (crypto.Signer, error) {
+	sum := sha256.Sum256([]byte(server + "|" + email))
+	secretName := AccountSecretPrefix + implicitAccountPrefix + hex.EncodeToString(sum[:8])
+
+	secret, err := m.clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err == nil {
+		if stored := secret.Annotations[accountAnnotationServer]; stored != server {
+			return nil, fmt.Errorf("账户Secret %s/%s 属于ACME服务器 %s，而不是 %s", ContextSecretNamespace, secretName, stored, server)
+		}
+		return parseAccountKey(secret.Data[accountKeyData])
+	} else if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("读取账户Secret %s/%s 失败: %v", ContextSecretNamespace, secretName, err)
+	}
+
+	keyPEM, err := readLegacyAccountKey(server)
+	if err != nil {
+		return nil, err
+	}
+	if keyPEM != nil {
+		utils.InfoLog("将 %s 上 %s 的ACME账户密钥从 %s 导入Secret %s/%s", server, valueOrNone(email), AcmeStateDir, ContextSecretNamespace, secretName)
+	} else {
+		utils.DebugLog("为 %s 上 %s 的ACME账户生成新密钥", server, valueOrNone(email))
+		key, err := generateAccountKey(AccountKeyEC256)
+		if err != nil {
+			return nil, err
+		}
+		if keyPEM, err = encodeAccountKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: ContextSecretNamespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "autocert",
+			},
+			Annotations: map[string]string{
+				accountAnnotationServer:   server,
+				accountAnnotationContacts: strings.Join(accountContacts(models.AccountConfig{Email: email}), ","),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{accountKeyData: keyPEM},
+	}
+	_, err = m.clientset.CoreV1().Secrets(ContextSecretNamespace).Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// 其他副本同时创建了同一个账户，使用已保存的密钥
+		return m.ImplicitKey(ctx, server, email)
+	} else if err != nil {
+		return nil, fmt.Errorf("保存账户Secret %s/%s 失败: %v", ContextSecretNamespace, secretName, err)
+	}
+	return parseAccountKey(keyPEM)
+}
+
+// readLegacyAccountKey 读取旧版本acme.sh保存在持久卷上的账户密钥，未设置 ACME_STATE_DIR 或文件不存在时返回nil
+// acme.sh每个CA只有一个账户，同一服务器上不同邮箱的隐式账户都会导入这个密钥
+func readLegacyAccountKey(server string) ([]byte, error) {
+	if AcmeStateDir == "" {
+		return nil, nil
+	}
+
+	path := legacyAccountKeyPath(server)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("读取旧的账户密钥 %s 失败: %v", path, err)
+	}
+	if _, err := parseAccountKey(data); err != nil {
+		return nil, fmt.Errorf("解析旧的账户密钥 %s 失败: %v", path, err)
+	}
+	return data, nil
+}
+
+// legacyAccountKeyPath 返回acme.sh保存账户密钥的路径: ca/<主机名>/<目录地址路径>/account.key
+// 与acme.sh一致，主机名不含端口，路径中连续的斜杠视为一个
+func legacyAccountKeyPath(server string) string {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return filepath.Join(AcmeStateDir, "ca", server, "account.key")
+	}
+	elems := []string{AcmeStateDir, "ca", u.Hostname()}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			elems = append(elems, segment)
+		}
+	}
+	return filepath.Join(append(elems, "account.key")...)
+}
+
+func (m *AccountManager) saveSecret(ctx context.Context, secret *corev1.Secret) error {
+	updated, err := m.clientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("更新账户Secret %s/%s 失败: %v", secret.Namespace, secret.Name, err)
+	}
+	secret.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+func (m *AccountManager) newClient(server string, key crypto.Signer) *acme.Client {
+	return &acme.Client{
+		Key:          key,
+		HTTPClient:   m.httpClient,
+		DirectoryURL: server,
+		UserAgent:    acmeUserAgent,
+	}
+}
+
+// accountContacts 合并email和contacts，没有协议前缀的联系方式视为邮箱
+func accountContacts(cfg models.AccountConfig) []string {
+	var contacts []string
+	seen := make(map[string]bool)
+	add := func(contact string) {
+		contact = strings.TrimSpace(contact)
+		if contact == "" {
+			return
+		}
+		if !strings.Contains(contact, ":") {
+			contact = "mailto:" + contact
+		}
+		if !seen[contact] {
+			seen[contact] = true
+			contacts = append(contacts, contact)
+		}
+	}
+	add(cfg.Email)
+	for _, contact := range cfg.Contacts {
+		add(contact)
+	}
+	return contacts
+}
+
+// generateAccountKey 按类型生成账户密钥
+func generateAccountKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "", AccountKeyEC256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AccountKeyEC384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AccountKeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AccountKeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("不支持的账户密钥类型 %q", keyType)
+	}
+}
+
+func encodeAccountKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// parseAccountKey 解析PKCS#8或SEC1格式的账户密钥
+func parseAccountKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid account key PEM")
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported account key type %T", key)
+	}
+	return signer, nil
+}
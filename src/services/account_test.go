@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+// TestAccountClientDoesNotBlockOtherAccounts 一个账户在CA注册缓慢时，其他账户的对齐和查询不受影响
+func TestAccountClientDoesNotBlockOtherAccounts(t *testing.T) {
+	slow := newACMEStub(t)
+	slow.registerGate = make(chan struct{})
+	fast := newACMEStub(t)
+
+	clientset := fake.NewSimpleClientset()
+	service := NewAcmeServiceWithHTTPClient(http.DefaultClient, clientset, nil, nil)
+	accounts := service.Accounts()
+	accounts.Configure([]models.AccountConfig{
+		{Name: "slow", Server: slow.URL(), Email: "slow@example.test"},
+		{Name: "fast", Server: fast.URL(), Email: "fast@example.test"},
+	}, "default")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := accounts.Client(ctx, "slow")
+		slowDone <- err
+	}()
+	// 等待慢服务器开始处理注册请求
+	for !slow.registering() {
+		time.Sleep(time.Millisecond)
+	}
+
+	fastClient, err := accounts.Client(ctx, "fast")
+	if err != nil {
+		t.Fatalf("Client(fast): %v", err)
+	}
+	if fastClient.KID == "" {
+		t.Error("fast account has no account URL")
+	}
+	if server, err := accounts.Server("slow"); err != nil || server != slow.URL() {
+		t.Errorf("Server(slow) = %q, %v", server, err)
+	}
+	select {
+	case err := <-slowDone:
+		t.Fatalf("slow registration finished early: %v", err)
+	default:
+	}
+
+	// 注册完成后账户被缓存，同一账户不会重复注册
+	close(slow.registerGate)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("Client(slow): %v", err)
+	}
+	first, _ := accounts.Client(ctx, "slow")
+	second, err := accounts.Client(ctx, "slow")
+	if err != nil || first != second {
+		t.Errorf("second Client(slow) = %p, %v, want the cached %p", second, err, first)
+	}
+	secret, err := clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, AccountSecretPrefix+"slow", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Annotations[accountAnnotationStatus] != accountStatusValid {
+		t.Errorf("slow account status = %q, want valid", secret.Annotations[accountAnnotationStatus])
+	}
+}
+
+// TestImplicitKeyImportsLegacyAcmeShKey 从旧版本acme.sh的 ca/<主机名>/<路径>/account.key 导入账户密钥
+func TestImplicitKeyImportsLegacyAcmeShKey(t *testing.T) {
+	defer func(dir string) { AcmeStateDir = dir }(AcmeStateDir)
+	AcmeStateDir = filepath.Join("testdata", "acme.sh")
+
+	for server, want := range map[string]string{
+		AcmeServers["letsencrypt"]:            "testdata/acme.sh/ca/acme-v02.api.letsencrypt.org/directory/account.key",
+		"https://ca.internal:9000/acme//dir/": "testdata/acme.sh/ca/ca.internal/acme/dir/account.key",
+	} {
+		if got := legacyAccountKeyPath(server); got != filepath.FromSlash(want) {
+			t.Errorf("legacyAccountKeyPath(%q) = %q, want %q", server, got, want)
+		}
+	}
+
+	legacy, err := os.ReadFile(legacyAccountKeyPath(AcmeServers["letsencrypt"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewSimpleClientset()
+	service := NewAcmeServiceWithHTTPClient(http.DefaultClient, clientset, nil, nil)
+	ctx := context.Background()
+
+	// acme.sh每个CA只有一个账户，不同邮箱都导入同一个密钥
+	for _, email := range []string{"ops@example.test", ""} {
+		if _, err := service.Accounts().ImplicitKey(ctx, AcmeServers["letsencrypt"], email); err != nil {
+			t.Fatalf("ImplicitKey(%q): %v", email, err)
+		}
+	}
+	if _, err := service.Accounts().ImplicitKey(ctx, AcmeServers["letsencrypt-staging"], "ops@example.test"); err != nil {
+		t.Fatalf("ImplicitKey(staging): %v", err)
+	}
+
+	secrets, err := clientset.CoreV1().Secrets(ContextSecretNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := 0
+	for _, secret := range secrets.Items {
+		server := secret.Annotations[accountAnnotationServer]
+		isLegacy := bytes.Equal(secret.Data[accountKeyData], legacy)
+		if isLegacy != (server == AcmeServers["letsencrypt"]) {
+			t.Errorf("account Secret %s for %s imported legacy key = %v", secret.Name, server, isLegacy)
+		}
+		if isLegacy {
+			imported++
+		}
+	}
+	if len(secrets.Items) != 3 || imported != 2 {
+		t.Errorf("created %d account Secrets with %d imported keys, want 3 and 2", len(secrets.Items), imported)
+	}
+}
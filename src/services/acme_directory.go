@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"me.sttot/auto-cert/src/utils"
+)
+
+// AcmeServers 常用ACME服务器的简称，证书的server字段可以直接使用简称
+var AcmeServers = map[string]string{
+	"letsencrypt":         "https://acme-v02.api.letsencrypt.org/directory",
+	"letsencrypt-staging": "https://acme-staging-v02.api.letsencrypt.org/directory",
+	"zerossl":             "https://acme.zerossl.com/v2/DV90",
+	"buypass":             "https://api.buypass.com/acme/directory",
+	"google":              "https://dv.acme-v02.api.pki.goog/directory",
+}
+
+var (
+	// 证书未配置server时使用的ACME服务器，可以是简称或目录地址
+	DefaultAcmeServer = getEnvOrDefault("DEFAULT_ACME_SERVER", "letsencrypt")
+)
+
+// AcmeDirectory ACME服务器目录中的地址和meta信息
+type AcmeDirectory struct {
+	URL         string
+	NewNonce    string
+	NewAccount  string
+	NewOrder    string
+	RenewalInfo string // 为空表示服务器不支持ARI
+
+	TermsOfService          string
+	Website                 string
+	CAAIdentities           []string
+	ExternalAccountRequired bool
+}
+
+// ResolveAcmeServer 将证书的server字段解析为目录地址：为空时使用DEFAULT_ACME_SERVER，简称替换为对应地址
+func ResolveAcmeServer(server string) (string, error) {
+	if server == "" {
+		if DefaultAcmeServer == "" {
+			return "", fmt.Errorf("没有配置ACME服务器，且 DEFAULT_ACME_SERVER 为空")
+		}
+		server = DefaultAcmeServer
+	}
+
+	if directory, ok := AcmeServers[strings.ToLower(server)]; ok {
+		return directory, nil
+	}
+
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("无效的ACME服务器 %q，应为目录地址或简称: %s", server, strings.Join(acmeServerNames(), ", "))
+	}
+	return server, nil
+}
+
+// acmeServerNames 返回排序后的服务器简称
+func acmeServerNames() []string {
+	names := make([]string, 0, len(AcmeServers))
+	for name := range AcmeServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Directory 获取并缓存ACME服务器的目录，server可以是简称
+func (a *AcmeService) Directory(ctx context.Context, server string) (*AcmeDirectory, error) {
+	directoryURL, err := ResolveAcmeServer(server)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	dir, ok := a.directories[directoryURL]
+	a.mu.Unlock()
+	if ok {
+		return dir, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, directoryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", acmeUserAgent)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch directory %s: %v", directoryURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch directory %s: %s", directoryURL, resp.Status)
+	}
+
+	var body struct {
+		NewNonce    string `json:"newNonce"`
+		NewAccount  string `json:"newAccount"`
+		NewOrder    string `json:"newOrder"`
+		RenewalInfo string `json:"renewalInfo"`
+		Meta        struct {
+			TermsOfService          string   `json:"termsOfService"`
+			Website                 string   `json:"website"`
+			CAAIdentities           []string `json:"caaIdentities"`
+			ExternalAccountRequired bool     `json:"externalAccountRequired"`
+		} `json:"meta"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("parse directory %s: %v", directoryURL, err)
+	}
+	if body.NewNonce == "" || body.NewAccount == "" || body.NewOrder == "" {
+		return nil, fmt.Errorf("%s is not an ACME directory: missing newNonce, newAccount or newOrder", directoryURL)
+	}
+
+	dir = &AcmeDirectory{
+		URL:                     directoryURL,
+		NewNonce:                body.NewNonce,
+		NewAccount:              body.NewAccount,
+		NewOrder:                body.NewOrder,
+		RenewalInfo:             body.RenewalInfo,
+		TermsOfService:          body.Meta.TermsOfService,
+		Website:                 body.Meta.Website,
+		CAAIdentities:           body.Meta.CAAIdentities,
+		ExternalAccountRequired: body.Meta.ExternalAccountRequired,
+	}
+
+	a.mu.Lock()
+	a.directories[directoryURL] = dir
+	a.mu.Unlock()
+	return dir, nil
+}
+
+// CheckDirectories 获取每个ACME服务器的目录并输出meta信息，已检查过的服务器不会重复获取
+// 返回无法获取目录的服务器对应的错误
+func (a *AcmeService) CheckDirectories(ctx context.Context, servers []string) []error {
+	var errs []error
+	checked := make(map[string]bool)
+	for _, server := range servers {
+		directoryURL, err := ResolveAcmeServer(server)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if checked[directoryURL] {
+			continue
+		}
+		checked[directoryURL] = true
+
+		a.mu.Lock()
+		_, cached := a.directories[directoryURL]
+		a.mu.Unlock()
+		if cached {
+			continue
+		}
+
+		dir, err := a.Directory(ctx, directoryURL)
+		if err != nil {
+			utils.WarningLog("无法获取ACME服务器 %s 的目录: %v", directoryURL, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		utils.InfoLog("ACME服务器 %s: 服务条款 %s，网站 %s，支持ARI: %v，需要外部账户绑定(EAB): %v",
+			dir.URL, valueOrNone(dir.TermsOfService), valueOrNone(dir.Website), dir.RenewalInfo != "", dir.ExternalAccountRequired)
+		if dir.ExternalAccountRequired {
+			utils.WarningLog("ACME服务器 %s 要求外部账户绑定(EAB)，使用它的证书需要配置eab", dir.URL)
+		}
+		if len(dir.CAAIdentities) > 0 {
+			utils.DebugLog("ACME服务器 %s 的CAA标识: %s", dir.URL, strings.Join(dir.CAAIdentities, ", "))
+		}
+	}
+	return errs
+}
+
+// valueOrNone 日志中将空值显示为"无"
+func valueOrNone(value string) string {
+	if value == "" {
+		return "无"
+	}
+	return value
+}
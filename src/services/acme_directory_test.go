@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+func TestDirectory(t *testing.T) {
+	stub := newACMEStub(t)
+	stub.meta = map[string]interface{}{
+		"termsOfService":          "https://ca.example.test/terms",
+		"website":                 "https://ca.example.test",
+		"caaIdentities":           []string{"ca.example.test"},
+		"externalAccountRequired": true,
+	}
+	service := NewAcmeServiceWithHTTPClient(stub.server.Client(), fake.NewSimpleClientset(), nil, nil)
+	ctx := context.Background()
+
+	dir, err := service.Directory(ctx, stub.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.TermsOfService != "https://ca.example.test/terms" || dir.Website != "https://ca.example.test" ||
+		len(dir.CAAIdentities) != 1 || dir.CAAIdentities[0] != "ca.example.test" || !dir.ExternalAccountRequired {
+		t.Errorf("directory meta = %+v", dir)
+	}
+	if dir.NewOrder != stub.server.URL+"/new-order" || dir.RenewalInfo != "" {
+		t.Errorf("directory endpoints = %+v", dir)
+	}
+
+	// 目录只获取一次，已缓存的服务器在检查时跳过
+	if again, err := service.Directory(ctx, stub.URL()); err != nil || again != dir {
+		t.Errorf("second Directory = %p, %v, want the cached %p", again, err, dir)
+	}
+	if errs := service.CheckDirectories(ctx, []string{stub.URL(), stub.URL()}); len(errs) != 0 {
+		t.Errorf("CheckDirectories: %v", errs)
+	}
+	if stub.directoryRequests != 1 {
+		t.Errorf("directory fetched %d times, want 1", stub.directoryRequests)
+	}
+
+	// 服务器要求EAB而证书没有配置时是配置错误，不会尝试注册账户
+	_, err = service.getClient(ctx, stub.URL(), "admin@example.test", nil)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("getClient without eab error = %v, want a ConfigError", err)
+	}
+	if stub.thumbprint != "" {
+		t.Error("registered an account without eab")
+	}
+
+	eab := &models.EABCredentials{KeyID: "kid-1", HMACKey: []byte("0123456789abcdef0123456789abcdef")}
+	if _, err := service.getClient(ctx, stub.URL(), "admin@example.test", eab); err != nil {
+		t.Fatalf("getClient with eab: %v", err)
+	}
+	if !stub.externalAccountBound {
+		t.Error("account registered without the external account binding")
+	}
+}
+
+func TestCheckDirectories(t *testing.T) {
+	plain := newACMEStub(t)
+	eabRequired := newACMEStub(t)
+	eabRequired.meta["externalAccountRequired"] = true
+	service := NewAcmeServiceWithHTTPClient(plain.server.Client(), fake.NewSimpleClientset(), nil, nil)
+	ctx := context.Background()
+
+	errs := service.CheckDirectories(ctx, []string{plain.URL(), eabRequired.URL(), plain.URL(), "not a server"})
+	if len(errs) != 1 {
+		t.Errorf("CheckDirectories errors = %v, want one for the invalid server", errs)
+	}
+	if errs := service.CheckDirectories(ctx, []string{plain.URL(), eabRequired.URL()}); len(errs) != 0 {
+		t.Errorf("second CheckDirectories: %v", errs)
+	}
+	if plain.directoryRequests != 1 || eabRequired.directoryRequests != 1 {
+		t.Errorf("directories fetched %d and %d times, want 1 each", plain.directoryRequests, eabRequired.directoryRequests)
+	}
+
+	dir, err := service.Directory(ctx, eabRequired.URL())
+	if err != nil || !dir.ExternalAccountRequired {
+		t.Errorf("cached directory = %+v, %v, want externalAccountRequired", dir, err)
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 进行中订单Secret的名称前缀，Secret位于 CONTEXT_SECRET_NAMESPACE，签发完成后删除
+	OrderSecretPrefix = getEnvOrDefault("ORDER_SECRET_PREFIX", "autocert-order-")
+)
+
+// 订单Secret中的键和注解
+const (
+	orderKeyData = "tls.key" // 订单完成时提交CSR使用的证书私钥
+
+	orderAnnotationCertificate = "autocert.sttot.me/certificate"
+	orderAnnotationURL         = "autocert.sttot.me/order-url"
+	orderAnnotationAccount     = "autocert.sttot.me/account-url"
+	orderAnnotationDomains     = "autocert.sttot.me/domains"
+)
+
+// pendingOrder 保存在Secret中的进行中订单，重启后从这里继续，而不是重新下单
+type pendingOrder struct {
+	order *acme.Order
+	key   *ecdsa.PrivateKey
+}
+
+// orderSecretName 返回证书对应的订单Secret名称，证书名可能包含"/"，因此使用哈希
+func orderSecretName(cert *models.Certificate) string {
+	sum := sha256.Sum256([]byte(cert.Name))
+	return OrderSecretPrefix + hex.EncodeToString(sum[:8])
+}
+
+// loadOrder 读取证书上次没有完成的订单，订单不存在、已失效或与当前配置不一致时返回nil
+func (a *AcmeService) loadOrder(ctx context.Context, client *acme.Client, cert *models.Certificate) (*pendingOrder, error) {
+	secretName := orderSecretName(cert)
+	secret, err := a.clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("读取订单Secret %s/%s 失败: %v", ContextSecretNamespace, secretName, err)
+	}
+
+	annotations := secret.Annotations
+	orderURL := annotations[orderAnnotationURL]
+	discard := func(reason string) (*pendingOrder, error) {
+		utils.InfoLog("证书 %s 上次的订单 %s %s，重新下单", cert.Name, valueOrNone(orderURL), reason)
+		a.deleteOrder(ctx, cert)
+		return nil, nil
+	}
+
+	switch {
+	case annotations[orderAnnotationCertificate] != cert.Name:
+		return discard("属于证书 " + annotations[orderAnnotationCertificate])
+	case orderURL == "":
+		return discard("没有记录订单地址")
+	case client.KID != "" && annotations[orderAnnotationAccount] != string(client.KID):
+		return discard("属于其他ACME账户")
+	case !sameDomains(strings.Split(annotations[orderAnnotationDomains], ","), cert.Domains):
+		return discard("的域名与配置不一致")
+	}
+
+	key, err := parseOrderKey(secret.Data[orderKeyData])
+	if err != nil {
+		return discard(fmt.Sprintf("的私钥无法解析(%v)", err))
+	}
+
+	order, err := client.GetOrder(ctx, orderURL)
+	if err != nil {
+		return discard(fmt.Sprintf("无法查询(%v)", err))
+	}
+	switch order.Status {
+	case acme.StatusPending, acme.StatusReady, acme.StatusProcessing:
+	case acme.StatusValid:
+		if order.CertURL == "" {
+			return discard("已完成但没有证书地址")
+		}
+	default:
+		return discard("状态为 " + order.Status)
+	}
+	if !order.Expires.IsZero() && time.Now().After(order.Expires) {
+		return discard("已过期")
+	}
+
+	utils.InfoLog("继续证书 %s 上次没有完成的订单 %s，状态: %s", cert.Name, order.URI, order.Status)
+	return &pendingOrder{order: order, key: key}, nil
+}
+
+// saveOrder 在开始验证前保存订单地址和证书私钥，进程重启后可以继续同一个订单
+func (a *AcmeService) saveOrder(ctx context.Context, client *acme.Client, cert *models.Certificate, p *pendingOrder) error {
+	der, err := x509.MarshalECPrivateKey(p.key)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      orderSecretName(cert),
+			Namespace: ContextSecretNamespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "autocert",
+				"autocert.sttot.me/order":      "true",
+			},
+			Annotations: map[string]string{
+				orderAnnotationCertificate: cert.Name,
+				orderAnnotationURL:         p.order.URI,
+				orderAnnotationAccount:     string(client.KID),
+				orderAnnotationDomains:     strings.Join(cert.Domains, ","),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			orderKeyData: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		},
+	}
+
+	secrets := a.clientset.CoreV1().Secrets(ContextSecretNamespace)
+	existing, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	} else if err == nil {
+		secret.ResourceVersion = existing.ResourceVersion
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("保存订单Secret %s/%s 失败: %v", ContextSecretNamespace, secret.Name, err)
+	}
+	utils.DebugLog("已将证书 %s 的订单 %s 保存到Secret %s/%s", cert.Name, p.order.URI, ContextSecretNamespace, secret.Name)
+	return nil
+}
+
+// deleteOrder 删除证书的订单Secret，失败只记录日志，残留的订单在下次签发时会被识别并丢弃
+func (a *AcmeService) deleteOrder(ctx context.Context, cert *models.Certificate) {
+	secretName := orderSecretName(cert)
+	err := a.clientset.CoreV1().Secrets(ContextSecretNamespace).Delete(ctx, secretName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		utils.WarningLog("删除订单Secret %s/%s 失败: %v", ContextSecretNamespace, secretName, err)
+	}
+}
+
+func parseOrderKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid order key PEM")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
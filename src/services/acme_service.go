@@ -2,299 +2,539 @@ package services
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"k8s.io/client-go/kubernetes"
 
 	"me.sttot/auto-cert/src/models"
 	"me.sttot/auto-cert/src/utils"
 )
 
 const (
-	// acme.sh 可能的位置
-	acmeShPath    = "/usr/local/bin/acme.sh"
-	certOutputDir = "/tmp/certs"
+	// ACME客户端标识
+	acmeUserAgent = "auto-cert"
+	// 单次签发流程（下单、验证、签发）的最长时间
+	orderTimeout = 30 * time.Minute
+)
+
+var (
+	// 旧版本保存ACME账户密钥的持久卷目录，设置后Secret中还没有的账户密钥会从这里导入
+	AcmeStateDir = getEnvOrDefault("ACME_STATE_DIR", "")
+	// 额外信任的CA证书文件，用于私有ACME服务器或本地测试服务器
+	AcmeCABundle = getEnvOrDefault("ACME_CA_BUNDLE", "")
+	// 添加TXT记录后等待DNS生效的时间
+	DNSSleep = getDurationFromEnv("DNS_SLEEP", 120*time.Second)
 )
 
-type AcmeService struct{}
+// AcmeService 内置的ACME(RFC 8555)客户端，负责账户注册、下单、验证和证书签发
+type AcmeService struct {
+	httpClient *http.Client
+	clientset  kubernetes.Interface
 
-func NewAcmeService() *AcmeService {
+	mu          sync.Mutex
+	clients     map[string]*acme.Client   // 按服务器和邮箱缓存已注册的账户
+	clientLocks map[string]*sync.Mutex    // 每个账户一把锁，注册一个账户时不阻塞其他账户
+	directories map[string]*AcmeDirectory // 按目录地址缓存服务器目录
+
+	accounts  *AccountManager     // 配置中的具名账户
+	http01    *HTTP01Responder    // 为nil时不支持http-01验证
+	tlsALPN01 *TLSALPN01Responder // 为nil时不支持tls-alpn-01验证
+}
+
+func NewAcmeService(clientset kubernetes.Interface, http01 *HTTP01Responder, tlsALPN01 *TLSALPN01Responder) *AcmeService {
 	utils.DebugLog("创建ACME服务")
-	// 确保输出目录存在
-	if err := os.MkdirAll(certOutputDir, 0755); err != nil {
-		utils.ErrorLog("警告: 创建证书输出目录失败: %v", err)
-	} else {
-		utils.DebugLog("证书输出目录: %s", certOutputDir)
+	httpClient, err := newAcmeHTTPClient(AcmeCABundle)
+	if err != nil {
+		utils.ErrorLog("警告: 加载CA证书文件 %s 失败，使用系统默认证书: %v", AcmeCABundle, err)
+		httpClient = http.DefaultClient
+	}
+	return NewAcmeServiceWithHTTPClient(httpClient, clientset, http01, tlsALPN01)
+}
+
+// NewAcmeServiceWithHTTPClient 使用指定的HTTP客户端创建ACME服务，便于对接本地测试服务器
+func NewAcmeServiceWithHTTPClient(httpClient *http.Client, clientset kubernetes.Interface, http01 *HTTP01Responder, tlsALPN01 *TLSALPN01Responder) *AcmeService {
+	a := &AcmeService{
+		httpClient:  httpClient,
+		clientset:   clientset,
+		clients:     make(map[string]*acme.Client),
+		clientLocks: make(map[string]*sync.Mutex),
+		directories: make(map[string]*AcmeDirectory),
+		http01:      http01,
+		tlsALPN01:   tlsALPN01,
+	}
+	a.accounts = newAccountManager(clientset, httpClient, a)
+	return a
+}
+
+// Accounts 返回具名账户管理器
+func (a *AcmeService) Accounts() *AccountManager {
+	return a.accounts
+}
+
+// newAcmeHTTPClient 创建访问ACME服务器的HTTP客户端，caBundle不为空时追加信任其中的CA
+func newAcmeHTTPClient(caBundle string) (*http.Client, error) {
+	if caBundle == "" {
+		return http.DefaultClient, nil
+	}
+
+	pemData, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
 	}
-	return &AcmeService{}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in %s", caBundle)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
 }
 
-// IssueCertificate 使用acme.sh签发新证书
+// IssueCertificate 签发新证书
 func (a *AcmeService) IssueCertificate(ctx context.Context, cert *models.Certificate) error {
 	utils.InfoLog("为域名 %v 签发新证书", cert.Domains)
 	utils.DebugLog("使用DNS提供商: %s, 服务器: %s", cert.DNSProvider, cert.Server)
 
-	// 准备命令参数
-	args := []string{
-		"--issue",
-		"--dns", cert.DNSProvider,
-		"--server", cert.Server,
+	if err := a.obtainCertificate(ctx, cert); err != nil {
+		utils.ErrorLog("证书签发失败: %v", err)
+		return err
 	}
 
-	// 添加所有域名
-	for _, domain := range cert.Domains {
-		args = append(args, "-d", domain)
+	utils.DebugLog("证书签发成功")
+	return nil
+}
+
+// RenewCertificate 续签证书
+// ACME协议没有单独的续签操作，续签即为相同域名重新下单
+func (a *AcmeService) RenewCertificate(ctx context.Context, cert *models.Certificate) error {
+	if len(cert.Domains) == 0 {
+		return fmt.Errorf("证书 %s 没有配置域名", cert.Name)
 	}
+	utils.InfoLog("为域名 %s 及其他 %d 个域名续签证书", cert.Domains[0], len(cert.Domains)-1)
 
-	// 添加email参数(如果提供)
-	if cert.Email != "" {
-		args = append(args, "--email", cert.Email)
-		utils.DebugLog("使用邮箱: %s", cert.Email)
+	if err := a.obtainCertificate(ctx, cert); err != nil {
+		utils.ErrorLog("证书续签失败: %v", err)
+		return err
 	}
 
-	// 设置环境变量
-	var env []string
-	if len(cert.Envs) > 0 {
-		utils.DebugLog("设置环境变量")
-		for key, value := range cert.Envs {
-			env = append(env, fmt.Sprintf("%s=%s", key, value))
-			utils.DebugLog("环境变量: %s=***", key) // 不打印实际值，保护隐私数据
-		}
+	utils.DebugLog("证书续签成功")
+	return nil
+}
+
+// ForceRenewCertificate 不论现有证书是否有效，强制重新签发证书
+func (a *AcmeService) ForceRenewCertificate(ctx context.Context, cert *models.Certificate) error {
+	utils.InfoLog("强制为域名 %v 重新签发证书", cert.Domains)
+	return a.obtainCertificate(ctx, cert)
+}
+
+// Issue 实现Issuer接口
+func (a *AcmeService) Issue(ctx context.Context, cert *models.Certificate) error {
+	return a.IssueCertificate(ctx, cert)
+}
+
+// Renew 实现Issuer接口
+func (a *AcmeService) Renew(ctx context.Context, cert *models.Certificate) error {
+	return a.RenewCertificate(ctx, cert)
+}
+
+// Revoke 使用证书自身的私钥向CA吊销证书，不依赖签发时使用的账户
+func (a *AcmeService) Revoke(ctx context.Context, cert *models.Certificate) error {
+	utils.InfoLog("吊销证书 %s", cert.Name)
+
+	keyPair, err := decodeKeyPair(cert)
+	if err != nil {
+		return err
+	}
+	signer, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("证书 %s 的私钥不支持签名", cert.Name)
 	}
 
-	utils.DebugLog("执行ACME命令颁发证书")
-	err := a.executeAcmeCommand(env, args, cert)
+	server, err := a.CertServer(cert)
 	if err != nil {
-		utils.ErrorLog("常规签发失败，尝试使用--force参数强制签发: %v", err)
-		// 常规签发失败，尝试强制签发
-		return a.ForceRenewCertificate(context.Background(), cert)
+		return err
 	}
 
-	utils.DebugLog("证书签发成功")
+	client := &acme.Client{
+		HTTPClient:   a.httpClient,
+		DirectoryURL: server,
+		UserAgent:    acmeUserAgent,
+	}
+	if err := client.RevokeCert(ctx, signer, keyPair.Certificate[0], acme.CRLReasonUnspecified); err != nil {
+		return fmt.Errorf("吊销证书失败: %v", err)
+	}
+
+	utils.InfoLog("证书 %s 已吊销", cert.Name)
 	return nil
 }
 
-// RenewCertificate 使用acme.sh续签证书
-func (a *AcmeService) RenewCertificate(ctx context.Context, cert *models.Certificate) error {
-	primaryDomain := cert.Domains[0]
-	utils.InfoLog("为域名 %s 及其他 %d 个域名续签证书", primaryDomain, len(cert.Domains)-1)
+// obtainCertificate 完成一次完整的ACME签发流程并将结果写入证书对象
+func (a *AcmeService) obtainCertificate(ctx context.Context, cert *models.Certificate) error {
+	if len(cert.Domains) == 0 {
+		return fmt.Errorf("证书 %s 没有配置域名", cert.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, orderTimeout)
+	defer cancel()
+
+	// 在下单前确认验证记录的CNAME委托，避免订单因验证失败而作废
+	if cert.Challenge == "" || cert.Challenge == ChallengeDNS01 {
+		if err := checkChallengeAliases(ctx, cert); err != nil {
+			return err
+		}
+	}
 
-	// 首先尝试常规续签
-	args := []string{
-		"--renew",
-		"-d", primaryDomain,
+	client, err := a.clientFor(ctx, cert)
+	if err != nil {
+		return err
 	}
 
-	// 添加所有域名
-	utils.DebugLog("包含域名: %v", cert.Domains)
-	for _, domain := range cert.Domains[1:] {
-		args = append(args, "-d", domain)
+	// 上次签发中断时继续原来的订单，否则创建新订单
+	pending, err := a.loadOrder(ctx, client, cert)
+	if err != nil {
+		return err
 	}
+	if pending == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("生成证书私钥失败: %v", err)
+		}
+
+		utils.DebugLog("为域名 %v 创建ACME订单", cert.Domains)
+		order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(cert.Domains...))
+		if err != nil {
+			return fmt.Errorf("创建订单失败: %v", err)
+		}
+		utils.DebugLog("订单已创建: %s, 状态: %s", order.URI, order.Status)
 
-	// 添加email参数(如果提供)
-	if cert.Email != "" {
-		args = append(args, "--email", cert.Email)
-		utils.DebugLog("使用邮箱: %s", cert.Email)
+		pending = &pendingOrder{order: order, key: key}
+		if err := a.saveOrder(ctx, client, cert, pending); err != nil {
+			return err
+		}
 	}
+	order, key := pending.order, pending.key
 
-	// 设置环境变量
-	var env []string
-	if len(cert.Envs) > 0 {
-		utils.DebugLog("设置环境变量")
-		for key, value := range cert.Envs {
-			env = append(env, fmt.Sprintf("%s=%s", key, value))
-			utils.DebugLog("环境变量: %s=***", key) // 不打印实际值，保护隐私数据
+	// 完成所有授权验证
+	if order.Status == acme.StatusPending {
+		if err := a.authorizeOrder(ctx, client, order, cert); err != nil {
+			return err
 		}
 	}
 
-	utils.DebugLog("执行ACME命令续签证书")
-	err := a.executeAcmeCommand(env, args, cert)
+	utils.DebugLog("等待订单 %s 就绪", order.URI)
+	order, err = client.WaitOrder(ctx, order.URI)
 	if err != nil {
-		utils.ErrorLog("常规续签失败，尝试强制重新签发: %v", err)
-		// 常规续签失败，尝试强制重新签发
-		return a.ForceRenewCertificate(context.Background(), cert)
+		// 订单已作废时不再继续，其他错误（如超时、进程退出）保留订单以便下次继续
+		var orderErr *acme.OrderError
+		if errors.As(err, &orderErr) {
+			a.deleteOrder(ctx, cert)
+		}
+		return fmt.Errorf("等待订单就绪失败: %v", err)
 	}
 
-	utils.DebugLog("证书续签成功")
+	var der [][]byte
+	if order.Status == acme.StatusReady {
+		csr, err := createCSR(key, cert.Domains)
+		if err != nil {
+			return fmt.Errorf("生成CSR失败: %v", err)
+		}
+
+		utils.DebugLog("提交CSR完成订单")
+		var certURL string
+		der, certURL, err = client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+		if err != nil {
+			return fmt.Errorf("完成订单失败: %v", err)
+		}
+		utils.DebugLog("证书已签发: %s，证书链包含 %d 个证书", certURL, len(der))
+	} else {
+		// 上次已提交CSR，直接下载签发好的证书
+		utils.DebugLog("订单 %s 已完成，下载证书: %s", order.URI, order.CertURL)
+		der, err = client.FetchCert(ctx, order.CertURL, true)
+		if err != nil {
+			return fmt.Errorf("下载证书失败: %v", err)
+		}
+	}
+	a.deleteOrder(ctx, cert)
+
+	certPEM, keyPEM, err := encodeCertificate(der, key)
+	if err != nil {
+		return err
+	}
+
+	// 将证书和密钥数据进行Base64编码并更新到证书对象
+	cert.CertData = base64.StdEncoding.EncodeToString(certPEM)
+	cert.KeyData = base64.StdEncoding.EncodeToString(keyPEM)
+	// 记录实际签发的目录地址，之后修改简称或DEFAULT_ACME_SERVER时按地址比较
+	cert.Server = client.DirectoryURL
+	utils.DebugLog("已将证书和密钥编码并保存到证书对象")
+
+	utils.InfoLog("证书处理完成")
 	return nil
 }
 
-// ForceRenewCertificate 强制使用acme.sh重新签发证书
-func (a *AcmeService) ForceRenewCertificate(ctx context.Context, cert *models.Certificate) error {
-	utils.InfoLog("强制为域名 %v 重新签发证书", cert.Domains)
+// authorizeOrder 为订单中所有待验证的授权布置验证资源并等待CA验证通过
+// 先布置全部资源再统一提交验证，这样通配符和根域名共用的_acme-challenge记录只需等待一次生效
+func (a *AcmeService) authorizeOrder(ctx context.Context, client *acme.Client, order *acme.Order, cert *models.Certificate) error {
+	challengeType, solver, err := a.newChallengeSolver(cert)
+	if err != nil {
+		return err
+	}
 
-	// 准备命令参数
-	args := []string{
-		"--issue",
-		"--force",
-		"--dns", cert.DNSProvider,
-		"--server", cert.Server,
+	type pendingChallenge struct {
+		authzURL string
+		domain   string
+		chal     *acme.Challenge
+		accept   bool // 恢复的订单中已经提交过的验证不再重复提交
 	}
 
-	// 添加所有域名
-	for _, domain := range cert.Domains {
-		args = append(args, "-d", domain)
+	var pending, presented []pendingChallenge
+	defer func() {
+		for _, p := range presented {
+			utils.DebugLog("清理域名 %s 的验证资源", p.domain)
+			if err := solver.CleanUp(context.Background(), client, p.domain, p.chal); err != nil {
+				utils.WarningLog("清理域名 %s 的验证资源失败: %v", p.domain, err)
+			}
+		}
+	}()
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return fmt.Errorf("获取授权信息失败: %v", err)
+		}
+		domain := authz.Identifier.Value
+		if authz.Status == acme.StatusValid {
+			utils.DebugLog("域名 %s 的授权仍然有效，跳过验证", domain)
+			continue
+		}
+
+		chal := findChallenge(authz, challengeType)
+		if chal == nil {
+			return fmt.Errorf("CA没有为域名 %s 提供%s验证方式", domain, challengeType)
+		}
+
+		p := pendingChallenge{authzURL: authz.URI, domain: domain, chal: chal}
+		switch chal.Status {
+		case acme.StatusPending:
+			p.accept = true
+		case acme.StatusProcessing:
+			// 上次已经提交，CA仍在验证；中断时验证资源可能已被清理，需要重新布置但不再提交
+			utils.InfoLog("域名 %s 的验证已提交，等待CA完成验证", domain)
+		case acme.StatusValid:
+			utils.DebugLog("域名 %s 的验证已通过，等待授权生效", domain)
+			pending = append(pending, p)
+			continue
+		default:
+			return fmt.Errorf("域名 %s 的%s验证状态为 %s，无法继续", domain, challengeType, chal.Status)
+		}
+
+		if err := solver.Present(ctx, client, domain, chal); err != nil {
+			return fmt.Errorf("布置域名 %s 的验证资源失败: %v", domain, err)
+		}
+		presented = append(presented, p)
+		pending = append(pending, p)
 	}
 
-	// 添加email参数(如果提供)
-	if cert.Email != "" {
-		args = append(args, "--email", cert.Email)
-		utils.DebugLog("使用邮箱: %s", cert.Email)
+	if len(pending) == 0 {
+		return nil
 	}
 
-	// 设置环境变量
-	var env []string
-	if len(cert.Envs) > 0 {
-		utils.DebugLog("设置环境变量")
-		for key, value := range cert.Envs {
-			env = append(env, fmt.Sprintf("%s=%s", key, value))
-			utils.DebugLog("环境变量: %s=***", key) // 不打印实际值，保护隐私数据
+	if len(presented) > 0 {
+		if err := solver.Wait(ctx); err != nil {
+			return err
 		}
 	}
 
-	utils.DebugLog("执行ACME命令强制重新签发证书")
-	return a.executeAcmeCommand(env, args, cert)
-}
-
-// executeAcmeCommand 执行acme.sh命令并处理结果
-func (a *AcmeService) executeAcmeCommand(env []string, args []string, cert *models.Certificate) error {
-	primaryDomain := cert.Domains[0]
-	utils.DebugLog("主域名: %s", primaryDomain)
+	for _, p := range pending {
+		if !p.accept {
+			continue
+		}
+		utils.DebugLog("通知CA验证域名 %s", p.domain)
+		if _, err := client.Accept(ctx, p.chal); err != nil {
+			return fmt.Errorf("提交域名 %s 的验证失败: %v", p.domain, err)
+		}
+	}
 
-	// 创建临时输出目录
-	outputDir := filepath.Join(certOutputDir, primaryDomain)
-	utils.DebugLog("创建证书输出目录: %s", outputDir)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		utils.ErrorLog("创建证书输出目录失败: %v", err)
-		return fmt.Errorf("创建证书输出目录失败: %v", err)
+	for _, p := range pending {
+		if _, err := client.WaitAuthorization(ctx, p.authzURL); err != nil {
+			return fmt.Errorf("域名 %s 验证失败: %v", p.domain, err)
+		}
+		utils.InfoLog("域名 %s 验证通过", p.domain)
 	}
 
-	// 添加输出目录参数
-	certFile := filepath.Join(outputDir, "cert.pem")
-	keyFile := filepath.Join(outputDir, "key.pem")
-	fullchainFile := filepath.Join(outputDir, "fullchain.pem")
+	return nil
+}
 
-	utils.DebugLog("证书文件路径: %s", certFile)
-	utils.DebugLog("密钥文件路径: %s", keyFile)
-	utils.DebugLog("完整证书链文件路径: %s", fullchainFile)
+// clientFor 返回签发证书使用的ACME客户端，引用了具名账户的证书使用该账户
+func (a *AcmeService) clientFor(ctx context.Context, cert *models.Certificate) (*acme.Client, error) {
+	if cert.Account != "" {
+		return a.accounts.Client(ctx, cert.Account)
+	}
+	return a.getClient(ctx, cert.Server, cert.Email, cert.ResolvedEAB)
+}
 
-	args = append(args, "--cert-file", certFile)
-	args = append(args, "--key-file", keyFile)
-	args = append(args, "--fullchain-file", fullchainFile)
+// CertServer 返回证书使用的ACME目录地址
+// 已签发的证书记录了签发时的目录地址；没有记录且引用了具名账户时使用账户的服务器
+func (a *AcmeService) CertServer(cert *models.Certificate) (string, error) {
+	if cert.Server == "" && cert.Account != "" {
+		return a.accounts.Server(cert.Account)
+	}
+	return ResolveAcmeServer(cert.Server)
+}
 
-	// 创建完整命令
-	cmd := exec.Command(acmeShPath, args...)
-	utils.DebugLog("执行命令: %s %v", acmeShPath, args)
+// getClient 获取指定服务器和邮箱对应的已注册ACME客户端，server可以是简称，为空时使用默认服务器
+// 账户只在第一次使用时注册，eab不为空时注册请求带上外部账户绑定
+func (a *AcmeService) getClient(ctx context.Context, server, email string, eab *models.EABCredentials) (*acme.Client, error) {
+	server, err := ResolveAcmeServer(server)
+	if err != nil {
+		return nil, err
+	}
+	if eab == nil {
+		if dir, err := a.Directory(ctx, server); err == nil && dir.ExternalAccountRequired {
+			return nil, &ConfigError{Err: fmt.Errorf("ACME服务器 %s 要求外部账户绑定，请在证书中配置eab", server)}
+		}
+	}
 
-	// 设置环境变量
-	if len(env) > 0 {
-		cmd.Env = append(os.Environ(), env...)
-		utils.DebugLog("设置了 %d 个环境变量", len(env))
+	// 只串行同一账户的注册，a.mu只保护缓存，不在网络请求期间持有
+	cacheKey := server + "|" + email
+	lock := a.clientLock(cacheKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	a.mu.Lock()
+	cached, ok := a.clients[cacheKey]
+	a.mu.Unlock()
+	if ok {
+		return cached, nil
 	}
 
-	// 修改为实时输出方式
-	// 创建标准输出和标准错误的管道
-	stdout, err := cmd.StdoutPipe()
+	key, err := a.accounts.ImplicitKey(ctx, server, email)
 	if err != nil {
-		utils.ErrorLog("创建输出管道失败: %v", err)
-		return fmt.Errorf("创建输出管道失败: %v", err)
+		return nil, fmt.Errorf("加载ACME账户密钥失败: %v", err)
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		utils.ErrorLog("创建错误输出管道失败: %v", err)
-		return fmt.Errorf("创建错误输出管道失败: %v", err)
-	}
-
-	// 创建一个缓冲区来存储所有输出，以便于后续使用
-	var outputBuffer strings.Builder
-
-	// 开始执行命令
-	utils.DebugLog("开始执行ACME命令")
-	if err := cmd.Start(); err != nil {
-		utils.ErrorLog("启动ACME命令失败: %v", err)
-		return fmt.Errorf("启动ACME命令失败: %v", err)
-	}
-
-	// 创建一个等待组来确保所有goroutine完成
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// 实时处理标准输出
-	go func() {
-		defer wg.Done()
-		buf := make([]byte, 1024)
-		for {
-			n, err := stdout.Read(buf)
-			if n > 0 {
-				output := string(buf[:n])
-				outputBuffer.WriteString(output)
-				utils.InfoLog("ACME输出: %s", output)
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
+	client := &acme.Client{
+		Key:          key,
+		HTTPClient:   a.httpClient,
+		DirectoryURL: server,
+		UserAgent:    acmeUserAgent,
+	}
 
-	// 实时处理标准错误
-	go func() {
-		defer wg.Done()
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				output := string(buf[:n])
-				outputBuffer.WriteString(output)
-				utils.InfoLog("ACME错误: %s", output)
-			}
-			if err != nil {
-				break
-			}
+	if eab != nil {
+		// EAB凭证可能只能使用一次，账户已存在时直接复用，不再重复提交绑定
+		if _, err := client.GetReg(ctx, ""); err == nil {
+			utils.DebugLog("复用 %s 上已绑定的ACME账户: %s", server, client.KID)
+			a.cacheClient(cacheKey, client)
+			return client, nil
+		} else if !errors.Is(err, acme.ErrNoAccount) {
+			return nil, fmt.Errorf("查询ACME账户失败: %v", err)
 		}
-	}()
+	}
 
-	// 等待命令执行完成
-	err = cmd.Wait()
+	account := &acme.Account{}
+	if email != "" {
+		account.Contact = []string{"mailto:" + email}
+	}
+	if eab != nil {
+		account.ExternalAccountBinding = &acme.ExternalAccountBinding{KID: eab.KeyID, Key: eab.HMACKey}
+		utils.InfoLog("使用外部账户绑定(key ID: %s)在 %s 注册ACME账户", eab.KeyID, server)
+	}
 
-	// 等待所有输出读取完毕
-	wg.Wait()
+	utils.DebugLog("在 %s 注册ACME账户", server)
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("注册ACME账户失败: %v", err)
+	}
+	utils.DebugLog("ACME账户: %s", client.KID)
 
-	if err != nil {
-		utils.ErrorLog("acme.sh 命令执行失败: %v", err)
-		return fmt.Errorf("acme.sh 命令执行失败: %v", err)
+	a.cacheClient(cacheKey, client)
+	return client, nil
+}
+
+// clientLock 返回账户对应的锁，不存在时创建
+func (a *AcmeService) clientLock(cacheKey string) *sync.Mutex {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lock, ok := a.clientLocks[cacheKey]
+	if !ok {
+		lock = &sync.Mutex{}
+		a.clientLocks[cacheKey] = lock
 	}
+	return lock
+}
+
+func (a *AcmeService) cacheClient(cacheKey string, client *acme.Client) {
+	a.mu.Lock()
+	a.clients[cacheKey] = client
+	a.mu.Unlock()
+}
 
-	utils.InfoLog("acme.sh 命令执行成功")
+// findChallenge 从授权中查找指定类型的验证方式
+func findChallenge(authz *acme.Authorization, typ string) *acme.Challenge {
+	for _, chal := range authz.Challenges {
+		if chal.Type == typ {
+			return chal
+		}
+	}
+	return nil
+}
 
-	// 读取生成的证书和密钥
-	utils.DebugLog("读取生成的证书和密钥文件")
-	certPath := filepath.Join(outputDir, "fullchain.pem")
-	keyPath := filepath.Join(outputDir, "key.pem")
+// createCSR 为域名列表生成DER格式的证书签名请求，第一个域名作为CommonName
+func createCSR(key crypto.Signer, domains []string) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}
+	return x509.CreateCertificateRequest(rand.Reader, template, key)
+}
 
-	certData, err := os.ReadFile(certPath)
+// decodeKeyPair 解码证书对象中Base64编码的证书链和私钥
+func decodeKeyPair(cert *models.Certificate) (tls.Certificate, error) {
+	certPEM, err := base64.StdEncoding.DecodeString(cert.CertData)
 	if err != nil {
-		utils.ErrorLog("读取证书文件失败: %v", err)
-		return fmt.Errorf("读取证书文件失败: %v", err)
+		return tls.Certificate{}, fmt.Errorf("decode certificate data: %v", err)
 	}
-	utils.DebugLog("成功读取证书文件，大小: %d 字节", len(certData))
-
-	keyData, err := os.ReadFile(keyPath)
+	keyPEM, err := base64.StdEncoding.DecodeString(cert.KeyData)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decode key data: %v", err)
+	}
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
-		utils.ErrorLog("读取密钥文件失败: %v", err)
-		return fmt.Errorf("读取密钥文件失败: %v", err)
+		return tls.Certificate{}, fmt.Errorf("parse key pair: %v", err)
 	}
-	utils.DebugLog("成功读取密钥文件，大小: %d 字节", len(keyData))
+	return keyPair, nil
+}
 
-	// 将证书和密钥数据进行Base64编码并更新到证书对象
-	cert.CertData = base64.StdEncoding.EncodeToString(certData)
-	cert.KeyData = base64.StdEncoding.EncodeToString(keyData)
-	utils.DebugLog("已将证书和密钥编码并保存到证书对象")
+// encodeCertificate 将证书链和私钥编码为PEM格式
+func encodeCertificate(der [][]byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	var certPEM []byte
+	for _, b := range der {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
 
-	utils.InfoLog("证书处理完成")
-	return nil
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("编码证书私钥失败: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
 }
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+// TestIssueCertificate 通过本地ACME服务器走完下单、授权、验证、完成订单和下载证书的完整流程
+func TestIssueCertificate(t *testing.T) {
+	stub := newACMEStub(t)
+	clientset := fake.NewSimpleClientset()
+	tlsALPN01 := NewTLSALPN01Responder(NewChallengeStore(nil))
+	addr := serveTLSALPN01(t, tlsALPN01)
+	stub.validate = func(typ, domain, token, keyAuth string) error {
+		return checkTLSALPN01(addr, domain, keyAuth)
+	}
+
+	// 未配置server的证书使用默认服务器，签发后记录实际的目录地址
+	defer func(server string) { DefaultAcmeServer = server }(DefaultAcmeServer)
+	DefaultAcmeServer = stub.URL()
+
+	service := NewAcmeServiceWithHTTPClient(stub.server.Client(), clientset, nil, tlsALPN01)
+	cert := &models.Certificate{
+		Name:      "example",
+		Domains:   []string{"example.test", "www.example.test"},
+		Email:     "admin@example.test",
+		Challenge: ChallengeTLSALPN01,
+	}
+	if err := service.IssueCertificate(context.Background(), cert); err != nil {
+		t.Fatalf("IssueCertificate: %v", err)
+	}
+	if cert.Server != stub.URL() {
+		t.Errorf("stored server = %q, want the resolved directory %q", cert.Server, stub.URL())
+	}
+
+	keyPair, err := decodeKeyPair(cert)
+	if err != nil {
+		t.Fatalf("decode issued certificate: %v", err)
+	}
+	if len(keyPair.Certificate) != 2 {
+		t.Errorf("certificate chain has %d certificates, want 2", len(keyPair.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.DNSNames) != 2 || leaf.DNSNames[0] != "example.test" || leaf.DNSNames[1] != "www.example.test" {
+		t.Errorf("certificate names = %v", leaf.DNSNames)
+	}
+	if err := leaf.CheckSignatureFrom(stub.caCert); err != nil {
+		t.Errorf("certificate is not signed by the stub CA: %v", err)
+	}
+
+	keyPEM, _ := base64.StdEncoding.DecodeString(cert.KeyData)
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Errorf("unexpected key data %q", keyPEM)
+	}
+
+	if stub.newOrderRequests != 1 {
+		t.Errorf("created %d orders, want 1", stub.newOrderRequests)
+	}
+	if stub.acceptRequests != 2 {
+		t.Errorf("accepted %d challenges, want 2", stub.acceptRequests)
+	}
+
+	// 签发完成后订单Secret被删除，账户密钥保存在Secret中
+	secrets, err := clientset.CoreV1().Secrets(ContextSecretNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	if len(names) != 1 || names[0] == orderSecretName(cert) {
+		t.Errorf("secrets after issuance = %v, want only the account key", names)
+	}
+
+	// 使用证书私钥吊销证书
+	if err := service.Revoke(context.Background(), cert); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if len(stub.revoked) != 1 || stub.revoked[0] != leaf.SerialNumber.Text(16) {
+		t.Errorf("revoked serials = %v, want %s", stub.revoked, leaf.SerialNumber.Text(16))
+	}
+}
+
+// TestIssueCertificateValidationFailed 验证失败时返回错误，不写入证书
+func TestIssueCertificateValidationFailed(t *testing.T) {
+	stub := newACMEStub(t)
+	tlsALPN01 := NewTLSALPN01Responder(NewChallengeStore(nil))
+	addr := serveTLSALPN01(t, tlsALPN01)
+	stub.validate = func(typ, domain, token, keyAuth string) error {
+		// 用错误的key authorization检查，模拟CA看到的内容不一致
+		return checkTLSALPN01(addr, domain, keyAuth+"x")
+	}
+
+	service := NewAcmeServiceWithHTTPClient(stub.server.Client(), fake.NewSimpleClientset(), nil, tlsALPN01)
+	cert := &models.Certificate{
+		Name:      "example",
+		Domains:   []string{"example.test"},
+		Server:    stub.URL(),
+		Challenge: ChallengeTLSALPN01,
+	}
+	if err := service.IssueCertificate(context.Background(), cert); err == nil {
+		t.Fatal("IssueCertificate succeeded, want validation error")
+	}
+	if cert.CertData != "" || cert.KeyData != "" {
+		t.Error("certificate data was written after a failed validation")
+	}
+}
+
+// TestIssueCertificateResumesOrder 继续上次中断的订单时，已通过的授权不再验证，已提交的验证不再重复提交
+func TestIssueCertificateResumesOrder(t *testing.T) {
+	stub := newACMEStub(t)
+	tlsALPN01 := NewTLSALPN01Responder(NewChallengeStore(nil))
+	addr := serveTLSALPN01(t, tlsALPN01)
+	var mu sync.Mutex
+	var validated []string
+	stub.validate = func(typ, domain, token, keyAuth string) error {
+		mu.Lock()
+		validated = append(validated, domain)
+		mu.Unlock()
+		return checkTLSALPN01(addr, domain, keyAuth)
+	}
+
+	service := NewAcmeServiceWithHTTPClient(stub.server.Client(), fake.NewSimpleClientset(), nil, tlsALPN01)
+	cert := &models.Certificate{
+		Name:      "example",
+		Domains:   []string{"a.example.test", "b.example.test", "c.example.test"},
+		Server:    stub.URL(),
+		Challenge: ChallengeTLSALPN01,
+	}
+	ctx := context.Background()
+
+	// 模拟上次签发在提交部分验证后中断：a已提交仍在验证，b已通过，c尚未提交
+	client, err := service.clientFor(ctx, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(cert.Domains...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.saveOrder(ctx, client, cert, &pendingOrder{order: order, key: key}); err != nil {
+		t.Fatal(err)
+	}
+	stub.setChallengeStatus("a.example.test", ChallengeTLSALPN01, acme.StatusProcessing)
+	stub.setChallengeStatus("b.example.test", ChallengeTLSALPN01, acme.StatusValid)
+
+	if err := service.IssueCertificate(ctx, cert); err != nil {
+		t.Fatalf("IssueCertificate: %v", err)
+	}
+	if stub.newOrderRequests != 1 {
+		t.Errorf("created %d orders, want the resumed one only", stub.newOrderRequests)
+	}
+	if stub.acceptRequests != 1 {
+		t.Errorf("accepted %d challenges, want 1 for c.example.test", stub.acceptRequests)
+	}
+	// a的验证在重新布置验证证书后才通过，b没有再次验证
+	mu.Lock()
+	defer mu.Unlock()
+	if !containsDomain(validated, "a.example.test") || !containsDomain(validated, "c.example.test") || containsDomain(validated, "b.example.test") {
+		t.Errorf("validated %v, want a.example.test and c.example.test", validated)
+	}
+}
+
+// TestGetClientDoesNotBlockOtherAccounts 一个ACME服务器注册缓慢时，其他服务器上的账户不受影响
+func TestGetClientDoesNotBlockOtherAccounts(t *testing.T) {
+	slow := newACMEStub(t)
+	slow.registerGate = make(chan struct{})
+	defer close(slow.registerGate)
+	fast := newACMEStub(t)
+
+	service := NewAcmeServiceWithHTTPClient(http.DefaultClient, fake.NewSimpleClientset(), nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := service.getClient(ctx, slow.URL(), "slow@example.test", nil)
+		slowDone <- err
+	}()
+	// 等待慢服务器开始处理注册请求
+	for !slow.registering() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := service.getClient(ctx, fast.URL(), "fast@example.test", nil); err != nil {
+		t.Fatalf("getClient on the fast server: %v", err)
+	}
+	select {
+	case err := <-slowDone:
+		t.Fatalf("slow registration finished early: %v", err)
+	default:
+	}
+}
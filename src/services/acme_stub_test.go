@@ -0,0 +1,545 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// acmeStub 本地的最小ACME服务器，按RFC 8555的流程响应acme.Client的请求
+// 不校验JWS签名；收到验证请求时调用validate，签发的证书由测试CA签名
+type acmeStub struct {
+	t      *testing.T
+	server *httptest.Server
+
+	// 目录中的meta字段
+	meta map[string]interface{}
+	// 处理 /renewal-info/ 下的请求，为nil时目录中不包含renewalInfo
+	renewalInfo http.HandlerFunc
+	// 验证挑战，返回错误时授权失败；为nil时直接通过
+	validate func(typ, domain, token, keyAuth string) error
+	// 不为nil时注册账户的请求等到该channel关闭后才返回，模拟响应缓慢的服务器
+	registerGate chan struct{}
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu         sync.Mutex
+	thumbprint string // 账户公钥的JWK指纹
+	orders     []*stubOrder
+	authzs     []*stubAuthz
+	certs      [][]byte // 签发的证书链PEM
+	revoked    []string // 被吊销证书的序列号
+
+	registerWaiting      bool
+	externalAccountBound bool // 注册账户的请求带有外部账户绑定
+	directoryRequests    int
+	newOrderRequests     int
+	acceptRequests       int
+}
+
+type stubOrder struct {
+	status  string
+	domains []string
+	authzs  []int
+	cert    int // 证书在certs中的下标加一，0表示尚未签发
+}
+
+type stubAuthz struct {
+	status     string
+	domain     string
+	challenges []*stubChallenge
+}
+
+type stubChallenge struct {
+	typ    string
+	token  string
+	status string
+}
+
+func newACMEStub(t *testing.T) *acmeStub {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "autocert test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &acmeStub{t: t, caKey: caKey, caCert: caCert, meta: map[string]interface{}{}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// registering 返回是否有注册请求正在等待registerGate
+func (s *acmeStub) registering() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registerWaiting
+}
+
+// URL 返回目录地址
+func (s *acmeStub) URL() string {
+	return s.server.URL + "/directory"
+}
+
+func (s *acmeStub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", "nonce-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	kind, id, _ := strings.Cut(path, "/")
+	if kind == "directory" {
+		s.serveDirectory(w)
+		return
+	}
+	if kind == "new-nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if kind == "renewal-info" && s.renewalInfo != nil {
+		s.renewalInfo(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, jwk := s.decodeJWS(r)
+	if kind == "new-account" && s.registerGate != nil {
+		s.mu.Lock()
+		s.registerWaiting = true
+		s.mu.Unlock()
+		<-s.registerGate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, _ := strconv.Atoi(id)
+	switch kind {
+	case "new-account":
+		var req struct {
+			OnlyReturnExisting     bool            `json:"onlyReturnExisting"`
+			ExternalAccountBinding json.RawMessage `json:"externalAccountBinding"`
+		}
+		json.Unmarshal(payload, &req)
+		if req.OnlyReturnExisting {
+			if s.thumbprint == "" || jwk == nil || jwkThumbprint(jwk) != s.thumbprint {
+				s.writeJSON(w, http.StatusBadRequest, map[string]interface{}{"type": "urn:ietf:params:acme:error:accountDoesNotExist"})
+				return
+			}
+			w.Header().Set("Location", s.server.URL+"/account/1")
+			s.writeJSON(w, http.StatusOK, map[string]interface{}{"status": "valid"})
+			return
+		}
+		if jwk != nil {
+			s.thumbprint = jwkThumbprint(jwk)
+		}
+		s.externalAccountBound = len(req.ExternalAccountBinding) > 0
+		w.Header().Set("Location", s.server.URL+"/account/1")
+		s.writeJSON(w, http.StatusCreated, map[string]interface{}{"status": "valid"})
+	case "account":
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"status": "valid"})
+	case "new-order":
+		s.newOrderRequests++
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		order := &stubOrder{status: acme.StatusPending}
+		for _, ident := range req.Identifiers {
+			order.domains = append(order.domains, ident.Value)
+			authz := &stubAuthz{status: acme.StatusPending, domain: ident.Value}
+			for _, typ := range []string{ChallengeHTTP01, ChallengeDNS01, ChallengeTLSALPN01} {
+				authz.challenges = append(authz.challenges, &stubChallenge{
+					typ:    typ,
+					token:  fmt.Sprintf("token-%d-%s", len(s.authzs), typ),
+					status: acme.StatusPending,
+				})
+			}
+			s.authzs = append(s.authzs, authz)
+			order.authzs = append(order.authzs, len(s.authzs)-1)
+		}
+		s.orders = append(s.orders, order)
+		w.Header().Set("Location", s.orderURL(len(s.orders)-1))
+		s.writeJSON(w, http.StatusCreated, s.orderJSON(len(s.orders)-1))
+	case "order":
+		w.Header().Set("Location", s.orderURL(index))
+		s.writeJSON(w, http.StatusOK, s.orderJSON(index))
+	case "authz":
+		// 查询授权时重试之前提交、仍在处理中的验证，失败时保持处理中
+		authz := s.authzs[index]
+		for _, chal := range authz.challenges {
+			if chal.status == acme.StatusProcessing {
+				s.completeChallenge(authz, chal, true)
+			}
+		}
+		s.writeJSON(w, http.StatusOK, s.authzJSON(index))
+	case "chal":
+		s.acceptRequests++
+		authzIndex, typ, _ := strings.Cut(id, "-")
+		index, _ = strconv.Atoi(authzIndex)
+		authz := s.authzs[index]
+		for _, chal := range authz.challenges {
+			if chal.typ != typ || chal.status != acme.StatusPending {
+				continue
+			}
+			chal.status = acme.StatusProcessing
+			s.completeChallenge(authz, chal, false)
+		}
+		s.writeJSON(w, http.StatusOK, s.challengeJSON(index, typ))
+	case "finalize":
+		order := s.orders[index]
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &req)
+		if order.status != acme.StatusReady {
+			s.writeJSON(w, http.StatusForbidden, map[string]interface{}{"type": "urn:ietf:params:acme:error:orderNotReady"})
+			return
+		}
+		csrDER, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		chain, err := s.issue(csrDER)
+		if err != nil {
+			s.writeJSON(w, http.StatusBadRequest, map[string]interface{}{"type": "urn:ietf:params:acme:error:badCSR", "detail": err.Error()})
+			return
+		}
+		s.certs = append(s.certs, chain)
+		order.cert = len(s.certs)
+		order.status = acme.StatusValid
+		w.Header().Set("Location", s.orderURL(index))
+		s.writeJSON(w, http.StatusOK, s.orderJSON(index))
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.certs[index])
+	case "revoke-cert":
+		var req struct {
+			Certificate string `json:"certificate"`
+		}
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.Certificate)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil || cert.CheckSignatureFrom(s.caCert) != nil {
+			http.Error(w, "unknown certificate", http.StatusBadRequest)
+			return
+		}
+		s.revoked = append(s.revoked, cert.SerialNumber.Text(16))
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *acmeStub) serveDirectory(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.directoryRequests++
+	s.mu.Unlock()
+
+	dir := map[string]interface{}{
+		"newNonce":   s.server.URL + "/new-nonce",
+		"newAccount": s.server.URL + "/new-account",
+		"newOrder":   s.server.URL + "/new-order",
+		"revokeCert": s.server.URL + "/revoke-cert",
+		"keyChange":  s.server.URL + "/key-change",
+		"meta":       s.meta,
+	}
+	if s.renewalInfo != nil {
+		dir["renewalInfo"] = s.server.URL + "/renewal-info/"
+	}
+	s.writeJSON(w, http.StatusOK, dir)
+}
+
+// decodeJWS 返回请求的payload和protected头中的jwk
+func (s *acmeStub) decodeJWS(r *http.Request) ([]byte, map[string]string) {
+	var body struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.t.Errorf("解析JWS失败: %v", err)
+		return nil, nil
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(body.Payload)
+	protected, _ := base64.RawURLEncoding.DecodeString(body.Protected)
+	var header struct {
+		JWK map[string]string `json:"jwk"`
+	}
+	json.Unmarshal(protected, &header)
+	return payload, header.JWK
+}
+
+// updateOrders 所有授权通过后订单变为ready，任一授权失败时订单失效
+func (s *acmeStub) updateOrders() {
+	for _, order := range s.orders {
+		if order.status != acme.StatusPending {
+			continue
+		}
+		ready := true
+		for _, i := range order.authzs {
+			switch s.authzs[i].status {
+			case acme.StatusInvalid:
+				order.status = acme.StatusInvalid
+			case acme.StatusValid:
+			default:
+				ready = false
+			}
+		}
+		if ready && order.status == acme.StatusPending {
+			order.status = acme.StatusReady
+		}
+	}
+}
+
+func (s *acmeStub) orderURL(index int) string {
+	return fmt.Sprintf("%s/order/%d", s.server.URL, index)
+}
+
+func (s *acmeStub) orderJSON(index int) map[string]interface{} {
+	order := s.orders[index]
+	var identifiers []map[string]string
+	for _, domain := range order.domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": domain})
+	}
+	var authzURLs []string
+	for _, i := range order.authzs {
+		authzURLs = append(authzURLs, fmt.Sprintf("%s/authz/%d", s.server.URL, i))
+	}
+	body := map[string]interface{}{
+		"status":         order.status,
+		"expires":        time.Now().Add(time.Hour).Format(time.RFC3339),
+		"identifiers":    identifiers,
+		"authorizations": authzURLs,
+		"finalize":       fmt.Sprintf("%s/finalize/%d", s.server.URL, index),
+	}
+	if order.cert > 0 {
+		body["certificate"] = fmt.Sprintf("%s/cert/%d", s.server.URL, order.cert-1)
+	}
+	return body
+}
+
+func (s *acmeStub) authzJSON(index int) map[string]interface{} {
+	authz := s.authzs[index]
+	var challenges []map[string]interface{}
+	for _, chal := range authz.challenges {
+		challenges = append(challenges, s.challengeJSON(index, chal.typ))
+	}
+	return map[string]interface{}{
+		"status":     authz.status,
+		"identifier": map[string]string{"type": "dns", "value": authz.domain},
+		"challenges": challenges,
+	}
+}
+
+func (s *acmeStub) challengeJSON(authzIndex int, typ string) map[string]interface{} {
+	for _, chal := range s.authzs[authzIndex].challenges {
+		if chal.typ == typ {
+			return map[string]interface{}{
+				"type":   chal.typ,
+				"url":    fmt.Sprintf("%s/chal/%d-%s", s.server.URL, authzIndex, chal.typ),
+				"token":  chal.token,
+				"status": chal.status,
+			}
+		}
+	}
+	return nil
+}
+
+// setChallengeStatus 修改域名所有授权中指定类型挑战的状态，模拟CA正在验证或已经验证完成
+func (s *acmeStub) setChallengeStatus(domain, typ, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, authz := range s.authzs {
+		if authz.domain != domain {
+			continue
+		}
+		for _, chal := range authz.challenges {
+			if chal.typ == typ {
+				chal.status = status
+			}
+		}
+		if status == acme.StatusValid {
+			authz.status = acme.StatusValid
+		}
+	}
+	s.updateOrders()
+}
+
+// completeChallenge 验证处理中的挑战并更新授权和订单状态，调用时持有s.mu
+// retry为true时验证失败不会使挑战失效，下次查询授权时再次验证
+func (s *acmeStub) completeChallenge(authz *stubAuthz, chal *stubChallenge, retry bool) {
+	if s.validate != nil {
+		// 验证过程中会连接本地的验证服务，此时不能持有锁
+		s.mu.Unlock()
+		err := s.validate(chal.typ, authz.domain, chal.token, chal.token+"."+s.thumbprint)
+		s.mu.Lock()
+		if err != nil {
+			s.t.Logf("验证域名 %s 失败: %v", authz.domain, err)
+			if retry {
+				return
+			}
+			chal.status = acme.StatusInvalid
+		}
+	}
+	if chal.status == acme.StatusProcessing {
+		chal.status = acme.StatusValid
+	}
+	authz.status = chal.status
+	s.updateOrders()
+}
+
+// issue 按CSR签发证书，返回包含测试CA的PEM证书链
+func (s *acmeStub) issue(csrDER []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...), nil
+}
+
+func (s *acmeStub) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	if status >= 400 {
+		w.Header().Set("Content-Type", "application/problem+json")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// jwkThumbprint 按RFC 7638计算JWK指纹
+func jwkThumbprint(jwk map[string]string) string {
+	var canonical string
+	switch jwk["kty"] {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk["crv"], jwk["x"], jwk["y"])
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk["e"], jwk["n"])
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// serveTLSALPN01 在本地随机端口启动TLS-ALPN-01验证服务，返回监听地址
+func serveTLSALPN01(t *testing.T, r *TLSALPN01Responder) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// idPeAcmeIdentifier TLS-ALPN-01验证证书中acmeIdentifier扩展的OID
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// checkTLSALPN01 像CA一样通过acme-tls/1连接验证服务，检查证书中的acmeIdentifier扩展
+func checkTLSALPN01(addr, domain, keyAuth string) error {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{acme.ALPNProto},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acme.ALPNProto {
+		return fmt.Errorf("negotiated protocol %q", state.NegotiatedProtocol)
+	}
+	if len(state.PeerCertificates) != 1 {
+		return fmt.Errorf("got %d certificates", len(state.PeerCertificates))
+	}
+	leaf := state.PeerCertificates[0]
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != domain {
+		return fmt.Errorf("certificate names %v, want %s", leaf.DNSNames, domain)
+	}
+
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(idPeAcmeIdentifier) {
+			continue
+		}
+		if !ext.Critical {
+			return fmt.Errorf("acmeIdentifier extension is not critical")
+		}
+		var got []byte
+		if _, err := asn1.Unmarshal(ext.Value, &got); err != nil {
+			return fmt.Errorf("parse acmeIdentifier: %v", err)
+		}
+		if string(got) != string(want[:]) {
+			return fmt.Errorf("acmeIdentifier does not match key authorization")
+		}
+		return nil
+	}
+	return fmt.Errorf("certificate has no acmeIdentifier extension")
+}
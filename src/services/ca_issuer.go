@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// CA签发证书的默认有效期
+const defaultCADuration = 90 * 24 * time.Hour
+
+// CAIssuer 使用保存在Secret中的自有CA签发证书，适用于仅内网可访问的域名
+// 自有CA不发布吊销列表，因此不实现Revoker
+type CAIssuer struct {
+	clientset kubernetes.Interface
+	secretRef models.SecretRef
+	duration  time.Duration
+}
+
+func NewCAIssuer(clientset kubernetes.Interface, cfg *models.CAIssuerConfig) (*CAIssuer, error) {
+	if cfg == nil || cfg.Secret.Name == "" {
+		return nil, fmt.Errorf("ca issuer requires a secret")
+	}
+
+	duration := defaultCADuration
+	if cfg.Duration != "" {
+		d, err := time.ParseDuration(cfg.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("parse duration %q: %v", cfg.Duration, err)
+		}
+		duration = d
+	}
+
+	secretRef := cfg.Secret
+	if secretRef.Namespace == "" {
+		secretRef.Namespace = ContextSecretNamespace
+	}
+
+	return &CAIssuer{
+		clientset: clientset,
+		secretRef: secretRef,
+		duration:  duration,
+	}, nil
+}
+
+// Issue 使用CA签发新证书
+func (ci *CAIssuer) Issue(ctx context.Context, cert *models.Certificate) error {
+	utils.InfoLog("使用CA %s/%s 为域名 %v 签发证书", ci.secretRef.Namespace, ci.secretRef.Name, cert.Domains)
+	if len(cert.Domains) == 0 {
+		return fmt.Errorf("证书 %s 没有配置域名", cert.Name)
+	}
+
+	ca, err := ci.loadCA(ctx)
+	if err != nil {
+		return err
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse ca certificate: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("生成证书私钥失败: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("生成证书序列号失败: %v", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cert.Domains[0]},
+		DNSNames:              cert.Domains,
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(ci.duration),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		return fmt.Errorf("签发证书失败: %v", err)
+	}
+
+	certPEM, keyPEM, err := encodeCertificate([][]byte{der, ca.Certificate[0]}, key)
+	if err != nil {
+		return err
+	}
+	cert.CertData = base64.StdEncoding.EncodeToString(certPEM)
+	cert.KeyData = base64.StdEncoding.EncodeToString(keyPEM)
+
+	utils.InfoLog("CA签发证书 %s 成功，有效期至 %s", cert.Name, template.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// Renew 自有CA的续签与签发相同
+func (ci *CAIssuer) Renew(ctx context.Context, cert *models.Certificate) error {
+	return ci.Issue(ctx, cert)
+}
+
+// loadCA 从Secret读取CA证书和私钥
+func (ci *CAIssuer) loadCA(ctx context.Context) (tls.Certificate, error) {
+	secret, err := ci.clientset.CoreV1().Secrets(ci.secretRef.Namespace).Get(ctx, ci.secretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("get ca secret %s/%s: %v", ci.secretRef.Namespace, ci.secretRef.Name, err)
+	}
+
+	ca, err := tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("parse ca secret %s/%s: %v", ci.secretRef.Namespace, ci.secretRef.Name, err)
+	}
+	if _, ok := ca.PrivateKey.(crypto.Signer); !ok {
+		return tls.Certificate{}, fmt.Errorf("ca private key in %s/%s cannot sign", ci.secretRef.Namespace, ci.secretRef.Name)
+	}
+	return ca, nil
+}
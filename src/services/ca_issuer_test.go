@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+// newTestCA 生成自签名CA并保存到Secret default/internal-ca
+func newTestCA(t *testing.T) (*fake.Clientset, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "internal CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "internal-ca"},
+		Data: map[string][]byte{
+			"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			"tls.key": pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	})
+	return clientset, caCert
+}
+
+func TestCAIssuerIssue(t *testing.T) {
+	clientset, caCert := newTestCA(t)
+	issuer, err := NewCAIssuer(clientset, &models.CAIssuerConfig{
+		Secret:   models.SecretRef{Namespace: "default", Name: "internal-ca"},
+		Duration: "720h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := interface{}(issuer).(Revoker); ok {
+		t.Error("ca issuer claims to support revocation")
+	}
+
+	cert := &models.Certificate{Name: "intranet", Domains: []string{"app.corp.example.test", "*.corp.example.test"}}
+	if err := issuer.Issue(context.Background(), cert); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := base64.StdEncoding.DecodeString(cert.CertData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := base64.StdEncoding.DecodeString(cert.KeyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("certificate and key do not match: %v", err)
+	}
+
+	// 证书链为叶子证书加CA证书
+	if len(keyPair.Certificate) != 2 {
+		t.Fatalf("chain has %d certificates, want 2", len(keyPair.Certificate))
+	}
+	if !caCert.Equal(mustParseCertificate(t, keyPair.Certificate[1])) {
+		t.Error("second certificate in the chain is not the CA")
+	}
+	leaf := mustParseCertificate(t, keyPair.Certificate[0])
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	for _, domain := range []string{"app.corp.example.test", "other.corp.example.test"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: domain, Roots: roots}); err != nil {
+			t.Errorf("verify %s: %v", domain, err)
+		}
+	}
+	if len(leaf.DNSNames) != 2 || leaf.DNSNames[0] != "app.corp.example.test" || leaf.DNSNames[1] != "*.corp.example.test" {
+		t.Errorf("SANs = %v", leaf.DNSNames)
+	}
+	if leaf.Subject.CommonName != "app.corp.example.test" {
+		t.Errorf("common name = %q", leaf.Subject.CommonName)
+	}
+	if lifetime := leaf.NotAfter.Sub(time.Now()); lifetime < 719*time.Hour || lifetime > 720*time.Hour {
+		t.Errorf("certificate valid for %s, want 720h", lifetime)
+	}
+	if leaf.IsCA {
+		t.Error("issued certificate is a CA")
+	}
+}
+
+func mustParseCertificate(t *testing.T, der []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -4,23 +4,32 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	"me.sttot/auto-cert/src/models"
 	"me.sttot/auto-cert/src/utils"
 )
 
 // 从环境变量获取配置，如果环境变量不存在则使用默认值
 var (
+	// 旧版本将所有证书保存在该Secret的context键中，启动后自动迁移为每个证书一个状态Secret
 	ContextSecretName      = getEnvOrDefault("CONTEXT_SECRET_NAME", "acmesh-autocert-context")
 	ContextSecretNamespace = getEnvOrDefault("CONTEXT_SECRET_NAMESPACE", "default")
+
+	// 证书未设置续签窗口时使用的全局默认值，RenewBeforePercentage 大于0时优先
+	RenewBefore           = getRenewBeforeFromEnv("RENEW_BEFORE", 30*24*time.Hour)
+	RenewBeforePercentage = getPercentageFromEnv("RENEW_BEFORE_PERCENTAGE")
 )
 
 // getEnvOrDefault 从环境变量获取值，如果不存在则返回默认值
@@ -31,131 +40,149 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-type Certificate struct {
-	Domain   string
-	CertPath string
-	KeyPath  string
-}
-
-type CertificateService struct {
-	clientset    *kubernetes.Clientset
-	certificates map[string]Certificate
-}
+// getDurationFromEnv 从环境变量解析时间间隔，如果不存在或无法解析则返回默认值
+func getDurationFromEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
 
-func NewCertificateService(clientset *kubernetes.Clientset) *CertificateService {
-	utils.DebugLog("创建证书服务")
-	return &CertificateService{
-		clientset:    clientset,
-		certificates: make(map[string]Certificate),
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		utils.WarningLog("无法解析%s环境变量 '%s', 使用默认值%s: %v", key, value, defaultValue, err)
+		return defaultValue
 	}
+	return duration
 }
 
-// LoadCertificateContext 从Kubernetes Secret加载证书上下文
-func (cs *CertificateService) LoadCertificateContext(ctx context.Context) (*models.CertificateContext, error) {
-	utils.DebugLog("从Secret %s/%s加载证书上下文", ContextSecretNamespace, ContextSecretName)
+// getRenewBeforeFromEnv 从环境变量解析续签窗口，支持 d 表示天
+func getRenewBeforeFromEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
 
-	secret, err := cs.clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, ContextSecretName, metav1.GetOptions{})
+	duration, err := ParseRenewBefore(value)
 	if err != nil {
-		// 如果Secret不存在，创建一个新的上下文
-		utils.DebugLog("证书上下文Secret不存在，创建新的上下文")
-		certContext := &models.CertificateContext{
-			Certificates: make(map[string]models.Certificate),
-		}
-		return certContext, nil
+		utils.WarningLog("无法解析%s环境变量 '%s', 使用默认值%s: %v", key, value, defaultValue, err)
+		return defaultValue
 	}
+	return duration
+}
 
-	dataJson, ok := secret.Data["context"]
-	if !ok {
-		utils.DebugLog("证书上下文Secret存在但没有context字段，创建新的上下文")
-		return &models.CertificateContext{
-			Certificates: make(map[string]models.Certificate),
-		}, nil
+// getPercentageFromEnv 从环境变量解析1-99之间的百分比，不存在或无效时返回0
+func getPercentageFromEnv(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
 	}
 
-	var certContext models.CertificateContext
-	if err := json.Unmarshal(dataJson, &certContext); err != nil {
-		utils.ErrorLog("解析证书上下文数据失败: %v", err)
-		return nil, fmt.Errorf("unmarshal certificate context: %v", err)
+	percentage, err := strconv.Atoi(value)
+	if err != nil || percentage < 1 || percentage > 99 {
+		utils.WarningLog("%s环境变量 '%s' 不是1-99之间的整数，忽略", key, value)
+		return 0
 	}
-
-	utils.DebugLog("成功加载证书上下文，包含%d个证书", len(certContext.Certificates))
-	return &certContext, nil
+	return percentage
 }
 
-// SaveCertificateContext 保存证书上下文到Kubernetes Secret
-func (cs *CertificateService) SaveCertificateContext(ctx context.Context, certContext *models.CertificateContext) error {
-	utils.DebugLog("保存证书上下文到Secret %s/%s", ContextSecretNamespace, ContextSecretName)
+// ParseRenewBefore 解析续签窗口，除 time.ParseDuration 的格式外还支持以 d 结尾的天数
+func ParseRenewBefore(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		if n <= 0 {
+			return 0, fmt.Errorf("duration %q must be positive", value)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
 
-	dataJson, err := json.Marshal(certContext)
+	duration, err := time.ParseDuration(value)
 	if err != nil {
-		utils.ErrorLog("序列化证书上下文失败: %v", err)
-		return fmt.Errorf("marshal certificate context: %v", err)
+		return 0, err
 	}
-
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      ContextSecretName,
-			Namespace: ContextSecretNamespace,
-		},
-		Data: map[string][]byte{
-			"context": dataJson,
-		},
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", value)
 	}
+	return duration, nil
+}
 
-	_, err = cs.clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, ContextSecretName, metav1.GetOptions{})
-	if err != nil {
-		// Secret不存在，创建新的
-		utils.DebugLog("创建证书上下文Secret")
-		_, err = cs.clientset.CoreV1().Secrets(ContextSecretNamespace).Create(ctx, secret, metav1.CreateOptions{})
-	} else {
-		// Secret存在，更新
-		utils.DebugLog("更新现有的证书上下文Secret")
-		_, err = cs.clientset.CoreV1().Secrets(ContextSecretNamespace).Update(ctx, secret, metav1.UpdateOptions{})
-	}
+type Certificate struct {
+	Domain   string
+	CertPath string
+	KeyPath  string
+}
 
-	if err != nil {
-		utils.ErrorLog("保存证书上下文失败: %v", err)
-	} else {
-		utils.DebugLog("证书上下文保存成功")
-	}
+type CertificateService struct {
+	clientset    kubernetes.Interface
+	certificates map[string]Certificate
+
+	// 旧版本单一上下文Secret的迁移只需成功执行一次
+	migrateMu sync.Mutex
+	migrated  bool
+}
 
-	return err
+func NewCertificateService(clientset kubernetes.Interface) *CertificateService {
+	utils.DebugLog("创建证书服务")
+	return &CertificateService{
+		clientset:    clientset,
+		certificates: make(map[string]Certificate),
+	}
 }
 
 // GetCertificate 获取特定域名的证书信息
 func (cs *CertificateService) GetCertificate(ctx context.Context, name string) (*models.Certificate, error) {
 	utils.DebugLog("获取证书 %s 的信息", name)
 
-	certContext, err := cs.LoadCertificateContext(ctx)
-	if err != nil {
-		utils.ErrorLog("加载证书上下文失败: %v", err)
+	if err := cs.migrateContext(ctx); err != nil {
 		return nil, err
 	}
 
-	cert, exists := certContext.Certificates[name]
-	if !exists {
+	secretName := stateSecretName(name)
+	secret, err := cs.clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
 		utils.DebugLog("证书 %s 不存在", name)
 		return nil, nil
+	} else if err != nil {
+		utils.ErrorLog("读取证书状态Secret %s/%s 失败: %v", ContextSecretNamespace, secretName, err)
+		return nil, fmt.Errorf("get certificate state %s/%s: %v", ContextSecretNamespace, secretName, err)
+	}
+
+	cert, err := decodeStateSecret(secret)
+	if err != nil {
+		return nil, fmt.Errorf("证书状态Secret %s/%s 无效: %v", ContextSecretNamespace, secretName, err)
+	}
+	if cert.Name != name {
+		return nil, fmt.Errorf("证书状态Secret %s/%s 属于证书 %s，而不是 %s", ContextSecretNamespace, secretName, cert.Name, name)
 	}
 
 	utils.DebugLog("找到证书 %s", name)
-	return &cert, nil
+	return cert, nil
 }
 
-// StoreCertificate 存储证书信息
+// StoreCertificate 将证书信息写入该证书自己的状态Secret
 func (cs *CertificateService) StoreCertificate(ctx context.Context, cert *models.Certificate) error {
 	utils.DebugLog("存储证书 %s 信息", cert.Name)
 
-	certContext, err := cs.LoadCertificateContext(ctx)
-	if err != nil {
-		utils.ErrorLog("加载证书上下文失败: %v", err)
+	if err := cs.migrateContext(ctx); err != nil {
 		return err
 	}
 
-	certContext.Certificates[cert.Name] = *cert
-	utils.DebugLog("添加/更新证书 %s 到上下文", cert.Name)
+	// 记录证书的签发和过期时间
+	if cert.CertData != "" {
+		if leaf, err := cs.ParseCertificateData(cert.CertData); err == nil {
+			cert.IssuedAt = leaf.NotBefore.Format(time.RFC3339)
+			cert.ExpiresAt = leaf.NotAfter.Format(time.RFC3339)
+		}
+	}
 
-	return cs.SaveCertificateContext(ctx, certContext)
+	if err := cs.writeStateSecret(ctx, cert, true); err != nil {
+		utils.ErrorLog("保存证书 %s 的状态失败: %v", cert.Name, err)
+		return err
+	}
+	utils.DebugLog("证书 %s 的状态保存成功", cert.Name)
+	return nil
 }
 
 // UpdateSecrets 更新Kubernetes Secret中的证书
@@ -185,28 +212,43 @@ func (cs *CertificateService) UpdateSecrets(ctx context.Context, cert *models.Ce
 	for _, secretRef := range cert.Secrets {
 		utils.DebugLog("更新Secret %s/%s", secretRef.Namespace, secretRef.Name)
 
-		secret := &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      secretRef.Name,
-				Namespace: secretRef.Namespace,
-			},
-			Type: corev1.SecretTypeTLS,
-			Data: map[string][]byte{
-				"tls.crt": certBytes,
-				"tls.key": keyBytes,
-			},
-		}
+		// 基于读取到的版本只替换证书和私钥，保留Secret中的其他数据、标签和注解；并发修改时重新读取后重试
+		secrets := cs.clientset.CoreV1().Secrets(secretRef.Namespace)
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			secret, err := secrets.Get(ctx, secretRef.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				// Secret不存在，创建新的
+				utils.DebugLog("Secret %s/%s 不存在，创建新的", secretRef.Namespace, secretRef.Name)
+				secret = &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      secretRef.Name,
+						Namespace: secretRef.Namespace,
+					},
+					Type: corev1.SecretTypeTLS,
+					Data: map[string][]byte{
+						"tls.crt": certBytes,
+						"tls.key": keyBytes,
+					},
+				}
+				_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+				if apierrors.IsAlreadyExists(err) {
+					return apierrors.NewConflict(corev1.Resource("secrets"), secretRef.Name, err)
+				}
+				return err
+			} else if err != nil {
+				return err
+			}
 
-		_, err = cs.clientset.CoreV1().Secrets(secretRef.Namespace).Get(ctx, secretRef.Name, metav1.GetOptions{})
-		if err != nil {
-			// Secret不存在，创建新的
-			utils.DebugLog("Secret %s/%s 不存在，创建新的", secretRef.Namespace, secretRef.Name)
-			_, err = cs.clientset.CoreV1().Secrets(secretRef.Namespace).Create(ctx, secret, metav1.CreateOptions{})
-		} else {
 			// Secret存在，更新
 			utils.DebugLog("Secret %s/%s 已存在，更新", secretRef.Namespace, secretRef.Name)
-			_, err = cs.clientset.CoreV1().Secrets(secretRef.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
-		}
+			if secret.Data == nil {
+				secret.Data = make(map[string][]byte)
+			}
+			secret.Data["tls.crt"] = certBytes
+			secret.Data["tls.key"] = keyBytes
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+			return err
+		})
 
 		if err != nil {
 			utils.ErrorLog("更新Secret %s/%s 失败: %v", secretRef.Namespace, secretRef.Name, err)
@@ -219,42 +261,112 @@ func (cs *CertificateService) UpdateSecrets(ctx context.Context, cert *models.Ce
 	return nil
 }
 
-// CheckCertificateExpiry 检查证书是否过期或即将过期
-func (cs *CertificateService) CheckCertificateExpiry(certData string) (bool, time.Time, error) {
-	utils.DebugLog("检查证书是否过期")
-
+// ParseCertificateData 解析Base64编码的PEM证书链，返回其中的第一个（叶子）证书
+func (cs *CertificateService) ParseCertificateData(certData string) (*x509.Certificate, error) {
 	certBytes, err := base64.StdEncoding.DecodeString(certData)
 	if err != nil {
 		utils.ErrorLog("解码证书数据失败: %v", err)
-		return false, time.Time{}, fmt.Errorf("decode certificate data: %v", err)
+		return nil, fmt.Errorf("decode certificate data: %v", err)
 	}
 
 	// 解析PEM格式证书
 	block, _ := pem.Decode(certBytes)
 	if block == nil {
 		utils.ErrorLog("解析PEM格式证书失败")
-		return false, time.Time{}, fmt.Errorf("failed to parse certificate PEM")
+		return nil, fmt.Errorf("failed to parse certificate PEM")
 	}
 
 	// 解析X.509证书
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
 		utils.ErrorLog("解析X.509证书失败: %v", err)
-		return false, time.Time{}, fmt.Errorf("parse certificate: %v", err)
+		return nil, fmt.Errorf("parse certificate: %v", err)
 	}
 
-	// 检查证书是否过期或即将过期（30天内）
-	now := time.Now()
-	expiryTime := cert.NotAfter
-	expiresInDays := int(expiryTime.Sub(now).Hours() / 24)
+	return cert, nil
+}
 
-	utils.DebugLog("证书有效期至 %s（还有%d天）", expiryTime.Format("2006-01-02"), expiresInDays)
+// CheckSpecDrift 比较已存储证书与期望配置，返回需要重新签发的原因，没有差异时返回空字符串
+// 域名以证书中实际包含的SAN为准，签发相关设置以签发时记录的配置为准；
+// ACME服务器比较serverOf解析出的目录地址，简称、目录地址和默认服务器指向同一地址时不算变化；
+// 引用具名账户的证书按账户解析服务器，因此修改账户的server也会重新签发
+func (cs *CertificateService) CheckSpecDrift(existing, desired *models.Certificate, serverOf func(*models.Certificate) (string, error)) string {
+	if existing.CertData != "" {
+		if leaf, err := cs.ParseCertificateData(existing.CertData); err == nil && !sameDomains(leaf.DNSNames, desired.Domains) {
+			return fmt.Sprintf("证书包含的域名 %v 与配置的域名 %v 不一致", leaf.DNSNames, desired.Domains)
+		}
+	}
+
+	existingServer, desiredServer := resolvedServer(existing, serverOf), resolvedServer(desired, serverOf)
+	switch {
+	case existing.DNSProvider != desired.DNSProvider:
+		return fmt.Sprintf("DNS提供商由 %q 变更为 %q", existing.DNSProvider, desired.DNSProvider)
+	case existingServer != desiredServer:
+		return fmt.Sprintf("ACME服务器由 %q 变更为 %q", existingServer, desiredServer)
+	case existing.Account != desired.Account:
+		return fmt.Sprintf("ACME账户由 %q 变更为 %q", existing.Account, desired.Account)
+	case existing.Issuer != desired.Issuer:
+		return fmt.Sprintf("签发者由 %q 变更为 %q", existing.Issuer, desired.Issuer)
+	}
+	return ""
+}
+
+// resolvedServer 返回证书的ACME目录地址，无法解析时返回server字段原样，由签发时报告错误
+func resolvedServer(cert *models.Certificate, serverOf func(*models.Certificate) (string, error)) string {
+	server, err := serverOf(cert)
+	if err != nil {
+		return cert.Server
+	}
+	return server
+}
+
+// sameDomains 忽略顺序和大小写比较两组域名
+func sameDomains(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, domain := range a {
+		set[strings.ToLower(domain)] = true
+	}
 
-	// 如果证书已过期或将在30天内过期，返回true
-	needsRenewal := expiresInDays < 30
-	if needsRenewal {
-		utils.DebugLog("证书将在30天内过期，需要续签")
+	seen := make(map[string]bool, len(b))
+	for _, domain := range b {
+		domain = strings.ToLower(domain)
+		if !set[domain] {
+			return false
+		}
+		seen[domain] = true
+	}
+	return len(seen) == len(set)
+}
+
+// renewBefore 计算证书的续签窗口，即过期前多久开始续签
+// 优先级：证书的 renewBeforePercentage、证书的 renewBefore、全局百分比、全局 RenewBefore；
+// 窗口不小于总有效期时（例如短期证书使用默认的30天），改为在剩余1/3有效期时续签
+func (cs *CertificateService) renewBefore(cert *models.Certificate, leaf *x509.Certificate) time.Duration {
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+
+	window := RenewBefore
+	switch {
+	case cert.RenewBeforePercentage > 0 && cert.RenewBeforePercentage < 100:
+		window = lifetime * time.Duration(cert.RenewBeforePercentage) / 100
+	case cert.RenewBefore != "":
+		duration, err := ParseRenewBefore(cert.RenewBefore)
+		if err != nil {
+			utils.WarningLog("证书 %s 的renewBefore '%s' 无效，使用全局默认值: %v", cert.Name, cert.RenewBefore, err)
+		} else {
+			window = duration
+		}
+	case RenewBeforePercentage > 0:
+		window = lifetime * time.Duration(RenewBeforePercentage) / 100
 	}
 
-	return needsRenewal, expiryTime, nil
+	if window >= lifetime {
+		utils.DebugLog("证书 %s 的续签窗口 %s 不小于有效期 %s，改为剩余1/3有效期时续签", cert.Name, window, lifetime)
+		window = lifetime / 3
+	}
+	return window
+}
+
+// RenewalTime 返回证书计划续签的时间
+func (cs *CertificateService) RenewalTime(cert *models.Certificate, leaf *x509.Certificate) time.Time {
+	return leaf.NotAfter.Add(-cs.renewBefore(cert, leaf))
 }
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+// TestCheckSpecDriftServer 简称、目录地址和默认服务器指向同一地址时不算变化
+func TestCheckSpecDriftServer(t *testing.T) {
+	defer func(server string) { DefaultAcmeServer = server }(DefaultAcmeServer)
+	DefaultAcmeServer = "letsencrypt"
+
+	cs := NewCertificateService(fake.NewSimpleClientset())
+	acmeService := NewAcmeServiceWithHTTPClient(http.DefaultClient, fake.NewSimpleClientset(), nil, nil)
+	drift := func(existing, desired string) string {
+		return cs.CheckSpecDrift(&models.Certificate{Server: existing}, &models.Certificate{Server: desired}, acmeService.CertServer)
+	}
+
+	letsencrypt := AcmeServers["letsencrypt"]
+	for _, existing := range []string{"letsencrypt", "LetsEncrypt", letsencrypt, ""} {
+		for _, desired := range []string{"letsencrypt", letsencrypt, ""} {
+			if reason := drift(existing, desired); reason != "" {
+				t.Errorf("server %q -> %q reported drift: %s", existing, desired, reason)
+			}
+		}
+	}
+	if reason := drift(letsencrypt, "letsencrypt-staging"); !strings.Contains(reason, AcmeServers["letsencrypt-staging"]) {
+		t.Errorf("switching to staging reported %q", reason)
+	}
+
+	// 修改默认服务器后，未配置server的证书按签发时记录的地址比较
+	DefaultAcmeServer = "zerossl"
+	if reason := drift(letsencrypt, ""); !strings.Contains(reason, AcmeServers["zerossl"]) {
+		t.Errorf("changing DEFAULT_ACME_SERVER reported %q", reason)
+	}
+	if reason := drift(AcmeServers["zerossl"], ""); reason != "" {
+		t.Errorf("certificate issued by the new default reported drift: %s", reason)
+	}
+}
+
+// TestCheckSpecDriftAccount 更换引用的账户或修改账户的服务器时需要重新签发
+func TestCheckSpecDriftAccount(t *testing.T) {
+	cs := NewCertificateService(fake.NewSimpleClientset())
+	acmeService := NewAcmeServiceWithHTTPClient(http.DefaultClient, fake.NewSimpleClientset(), nil, nil)
+	acmeService.accounts.Configure([]models.AccountConfig{
+		{Name: "prod", Server: "letsencrypt", Email: "ops@example.test"},
+		{Name: "backup", Server: "letsencrypt", Email: "ops@example.test"},
+	}, "")
+
+	// 签发后记录的是账户服务器的目录地址
+	existing := &models.Certificate{Account: "prod", Server: AcmeServers["letsencrypt"]}
+	if reason := cs.CheckSpecDrift(existing, &models.Certificate{Account: "prod"}, acmeService.CertServer); reason != "" {
+		t.Errorf("unchanged account reported drift: %s", reason)
+	}
+	if reason := cs.CheckSpecDrift(existing, &models.Certificate{Account: "backup"}, acmeService.CertServer); !strings.Contains(reason, `"backup"`) {
+		t.Errorf("switching account reported %q", reason)
+	}
+	if reason := cs.CheckSpecDrift(existing, &models.Certificate{Server: "letsencrypt"}, acmeService.CertServer); !strings.Contains(reason, "ACME账户") {
+		t.Errorf("dropping account reported %q", reason)
+	}
+
+	acmeService.accounts.Configure([]models.AccountConfig{
+		{Name: "prod", Server: "letsencrypt-staging", Email: "ops@example.test"},
+	}, "")
+	if reason := cs.CheckSpecDrift(existing, &models.Certificate{Account: "prod"}, acmeService.CertServer); !strings.Contains(reason, AcmeServers["letsencrypt-staging"]) {
+		t.Errorf("changing the account server reported %q", reason)
+	}
+}
+
+// TestUpdateSecretsConflict 更新目标Secret冲突时重新读取，保留其他写入者同时做出的修改
+func TestUpdateSecretsConflict(t *testing.T) {
+	ctx := context.Background()
+	target := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-tls", Namespace: "team-a", ResourceVersion: "1"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{"tls.crt": []byte("old-cert"), "tls.key": []byte("old-key")},
+	}
+	clientset := fake.NewSimpleClientset(target)
+
+	// 第一次更新前有其他控制器写入了ca.crt和注解，本次更新基于旧版本，返回冲突
+	updates := 0
+	clientset.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		concurrent := target.DeepCopy()
+		concurrent.ResourceVersion = "2"
+		concurrent.Annotations = map[string]string{"reflector.example.test/reflect": "true"}
+		concurrent.Data["ca.crt"] = []byte("ca-cert")
+		if err := clientset.Tracker().Update(corev1.SchemeGroupVersion.WithResource("secrets"), concurrent, "team-a"); err != nil {
+			t.Errorf("concurrent update: %v", err)
+		}
+		return true, nil, apierrors.NewConflict(corev1.Resource("secrets"), "app-tls", nil)
+	})
+
+	cert := &models.Certificate{
+		Name:     "app",
+		CertData: base64.StdEncoding.EncodeToString([]byte("new-cert")),
+		KeyData:  base64.StdEncoding.EncodeToString([]byte("new-key")),
+		Secrets:  []models.SecretRef{{Name: "app-tls", Namespace: "team-a"}},
+	}
+	if err := NewCertificateService(clientset).UpdateSecrets(ctx, cert); err != nil {
+		t.Fatalf("UpdateSecrets: %v", err)
+	}
+
+	var verbs []string
+	for _, action := range clientset.Actions() {
+		verbs = append(verbs, action.GetVerb())
+	}
+	if strings.Join(verbs, ",") != "get,update,get,update" {
+		t.Errorf("actions = %v, want the second update after a fresh get", verbs)
+	}
+	secret, err := clientset.CoreV1().Secrets("team-a").Get(ctx, "app-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"tls.crt": "new-cert", "tls.key": "new-key", "ca.crt": "ca-cert"} {
+		if got := string(secret.Data[key]); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if secret.Annotations["reflector.example.test/reflect"] != "true" {
+		t.Errorf("concurrent annotation lost: %v", secret.Annotations)
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 证书状态Secret的名称前缀，每个证书一个，位于 CONTEXT_SECRET_NAMESPACE
+	StateSecretPrefix = getEnvOrDefault("STATE_SECRET_PREFIX", "autocert-state-")
+)
+
+// StateSecretSelector 选择所有证书状态Secret的标签选择器，可用于 kubectl get secret -l
+const StateSecretSelector = "app.kubernetes.io/managed-by=autocert,autocert.sttot.me/state=true"
+
+// 状态Secret中的键和注解
+const (
+	stateCertificateData = "certificate" // 证书信息的JSON，与旧版本context中的单个条目格式相同
+
+	stateAnnotationCertificate = "autocert.sttot.me/certificate"
+	// 旧的上下文Secret迁移完成后记录迁移时间，之后不再读取
+	contextAnnotationMigrated = "autocert.sttot.me/migrated"
+)
+
+// stateSecretName 返回证书状态Secret的名称：可读的证书名加上哈希，证书名可能包含"/"、"."或大写字母
+func stateSecretName(name string) string {
+	readable := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+	if len(readable) > 40 {
+		readable = readable[:40]
+	}
+	readable = strings.Trim(readable, "-")
+
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:5])
+	if readable == "" {
+		return StateSecretPrefix + hash
+	}
+	return StateSecretPrefix + readable + "-" + hash
+}
+
+// decodeStateSecret 解析状态Secret中保存的证书信息
+func decodeStateSecret(secret *corev1.Secret) (*models.Certificate, error) {
+	data, ok := secret.Data[stateCertificateData]
+	if !ok {
+		return nil, fmt.Errorf("missing %s", stateCertificateData)
+	}
+	var cert models.Certificate
+	if err := json.Unmarshal(data, &cert); err != nil {
+		return nil, fmt.Errorf("unmarshal certificate: %v", err)
+	}
+	return &cert, nil
+}
+
+// writeStateSecret 创建或更新证书的状态Secret，更新时携带读取时的resourceVersion，冲突时重新读取后重试
+// overwrite为false时已存在的状态Secret保持不变，用于迁移时不覆盖较新的状态
+func (cs *CertificateService) writeStateSecret(ctx context.Context, cert *models.Certificate, overwrite bool) error {
+	data, err := json.Marshal(cert)
+	if err != nil {
+		return fmt.Errorf("marshal certificate %s: %v", cert.Name, err)
+	}
+
+	secrets := cs.clientset.CoreV1().Secrets(ContextSecretNamespace)
+	secretName := stateSecretName(cert.Name)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, secretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			utils.DebugLog("创建证书 %s 的状态Secret %s/%s", cert.Name, ContextSecretNamespace, secretName)
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      secretName,
+					Namespace: ContextSecretNamespace,
+					Labels: map[string]string{
+						"app.kubernetes.io/managed-by": "autocert",
+						"autocert.sttot.me/state":      "true",
+					},
+					Annotations: map[string]string{
+						stateAnnotationCertificate: cert.Name,
+					},
+				},
+				Type: corev1.SecretTypeOpaque,
+				Data: map[string][]byte{stateCertificateData: data},
+			}
+			_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("secrets"), secretName, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if owner := secret.Annotations[stateAnnotationCertificate]; owner != cert.Name {
+			return fmt.Errorf("状态Secret %s/%s 属于证书 %s，而不是 %s", ContextSecretNamespace, secretName, owner, cert.Name)
+		}
+		if !overwrite {
+			return nil
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[stateCertificateData] = data
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// migrateContext 将旧版本上下文Secret中的证书逐个写入状态Secret，已存在的状态Secret不会被覆盖
+// 成功后在旧Secret上记录迁移时间，旧数据保留作为备份，确认无误后可以手动删除
+func (cs *CertificateService) migrateContext(ctx context.Context) error {
+	cs.migrateMu.Lock()
+	defer cs.migrateMu.Unlock()
+	if cs.migrated {
+		return nil
+	}
+
+	secrets := cs.clientset.CoreV1().Secrets(ContextSecretNamespace)
+	legacy, err := secrets.Get(ctx, ContextSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cs.migrated = true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("读取旧的证书上下文Secret %s/%s 失败: %v", ContextSecretNamespace, ContextSecretName, err)
+	}
+
+	dataJson, ok := legacy.Data["context"]
+	if !ok || legacy.Annotations[contextAnnotationMigrated] != "" {
+		cs.migrated = true
+		return nil
+	}
+
+	var certContext models.CertificateContext
+	if err := json.Unmarshal(dataJson, &certContext); err != nil {
+		return fmt.Errorf("解析旧的证书上下文失败: %v", err)
+	}
+
+	utils.InfoLog("将 %s/%s 中的 %d 个证书迁移为独立的状态Secret", ContextSecretNamespace, ContextSecretName, len(certContext.Certificates))
+	for name, cert := range certContext.Certificates {
+		cert.Name = name
+		if err := cs.writeStateSecret(ctx, &cert, false); err != nil {
+			return fmt.Errorf("迁移证书 %s 失败: %v", name, err)
+		}
+	}
+
+	if legacy.Annotations == nil {
+		legacy.Annotations = make(map[string]string)
+	}
+	legacy.Annotations[contextAnnotationMigrated] = time.Now().UTC().Format(time.RFC3339)
+	if _, err := secrets.Update(ctx, legacy, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("标记旧的证书上下文Secret %s/%s 已迁移失败: %v", ContextSecretNamespace, ContextSecretName, err)
+	}
+
+	utils.InfoLog("证书上下文迁移完成，旧的Secret %s/%s 已不再使用，确认无误后可以删除", ContextSecretNamespace, ContextSecretName)
+	cs.migrated = true
+	return nil
+}
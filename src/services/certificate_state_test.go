@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+// newLegacyContext 创建旧版本保存全部证书的上下文Secret
+func newLegacyContext(t *testing.T, certs map[string]models.Certificate) *corev1.Secret {
+	t.Helper()
+	data, err := json.Marshal(&models.CertificateContext{Certificates: certs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ContextSecretName, Namespace: ContextSecretNamespace},
+		Data:       map[string][]byte{"context": data},
+	}
+}
+
+// TestMigrateContext 旧的上下文Secret中每个证书迁移为一个状态Secret，已有状态的证书不被覆盖，迁移只执行一次
+func TestMigrateContext(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	// 新版本已经为api写入了较新的状态
+	if err := NewCertificateService(clientset).StoreCertificate(ctx, &models.Certificate{Name: "api", Domains: []string{"api.example.test", "api2.example.test"}}); err != nil {
+		t.Fatal(err)
+	}
+	legacy := newLegacyContext(t, map[string]models.Certificate{
+		"web":        {Domains: []string{"web.example.test"}, DNSProvider: "dns_cf"},
+		"team-a/app": {Domains: []string{"app.team-a.test"}},
+		"api":        {Domains: []string{"api.example.test"}},
+	})
+	if _, err := clientset.CoreV1().Secrets(ContextSecretNamespace).Create(ctx, legacy, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := NewCertificateService(clientset)
+	for name, domains := range map[string]string{
+		"web":        "web.example.test",
+		"team-a/app": "app.team-a.test",
+		"api":        "api.example.test,api2.example.test",
+	} {
+		cert, err := cs.GetCertificate(ctx, name)
+		if err != nil {
+			t.Fatalf("GetCertificate(%s): %v", name, err)
+		}
+		if cert == nil || cert.Name != name || strings.Join(cert.Domains, ",") != domains {
+			t.Errorf("GetCertificate(%s) = %+v, want domains %s", name, cert, domains)
+		}
+	}
+
+	states, err := clientset.CoreV1().Secrets(ContextSecretNamespace).List(ctx, metav1.ListOptions{LabelSelector: StateSecretSelector})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states.Items) != 3 {
+		t.Errorf("found %d state Secrets, want 3", len(states.Items))
+	}
+	for _, secret := range states.Items {
+		if !strings.HasPrefix(secret.Name, StateSecretPrefix) || secret.Name != stateSecretName(secret.Annotations[stateAnnotationCertificate]) {
+			t.Errorf("state Secret %s owned by %q", secret.Name, secret.Annotations[stateAnnotationCertificate])
+		}
+	}
+	legacy, err = clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, ContextSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Annotations[contextAnnotationMigrated] == "" {
+		t.Fatal("legacy context was not marked as migrated")
+	}
+	if _, ok := legacy.Data["context"]; !ok {
+		t.Error("legacy context data was removed")
+	}
+
+	// 标记迁移后，即使状态Secret被删除，重启后的实例也不会再次迁移
+	if err := clientset.CoreV1().Secrets(ContextSecretNamespace).Delete(ctx, stateSecretName("web"), metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	clientset.ClearActions()
+	cert, err := NewCertificateService(clientset).GetCertificate(ctx, "web")
+	if err != nil || cert != nil {
+		t.Errorf("GetCertificate(web) after deletion = %+v, %v, want nothing", cert, err)
+	}
+	for _, action := range clientset.Actions() {
+		if verb := action.GetVerb(); verb != "get" {
+			t.Errorf("second migration issued %s %s", verb, action.GetResource().Resource)
+		}
+	}
+}
+
+// TestMigrateContextOwnerCollision 同名的状态Secret属于其他证书时迁移失败，不覆盖该Secret也不标记迁移完成
+func TestMigrateContextOwnerCollision(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	other := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        stateSecretName("web"),
+			Namespace:   ContextSecretNamespace,
+			Annotations: map[string]string{stateAnnotationCertificate: "other"},
+		},
+		Data: map[string][]byte{stateCertificateData: []byte(`{"name":"other"}`)},
+	}
+	legacy := newLegacyContext(t, map[string]models.Certificate{"web": {Domains: []string{"web.example.test"}}})
+	for _, secret := range []*corev1.Secret{other, legacy} {
+		if _, err := clientset.CoreV1().Secrets(ContextSecretNamespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cs := NewCertificateService(clientset)
+	if _, err := cs.GetCertificate(ctx, "web"); err == nil || !strings.Contains(err.Error(), "other") {
+		t.Fatalf("GetCertificate(web) error = %v, want an owner collision", err)
+	}
+
+	stored, err := clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, other.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Data[stateCertificateData]) != `{"name":"other"}` || stored.Annotations[stateAnnotationCertificate] != "other" {
+		t.Errorf("colliding state Secret was overwritten: %v %s", stored.Annotations, stored.Data[stateCertificateData])
+	}
+	legacy, err = clientset.CoreV1().Secrets(ContextSecretNamespace).Get(ctx, ContextSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Annotations[contextAnnotationMigrated] != "" {
+		t.Error("failed migration marked the legacy context as migrated")
+	}
+}
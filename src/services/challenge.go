@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// 证书可选的ACME验证方式
+const (
+	ChallengeDNS01     = "dns-01"
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// challengeSolver 布置和清理一种ACME验证方式所需的资源
+type challengeSolver interface {
+	// Present 为域名布置验证资源
+	Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error
+	// Wait 在通知CA验证之前等待已布置的资源生效
+	Wait(ctx context.Context) error
+	// CleanUp 清理Present布置的资源
+	CleanUp(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error
+}
+
+// newChallengeSolver 根据证书配置的验证方式创建对应的solver，返回验证类型
+func (a *AcmeService) newChallengeSolver(cert *models.Certificate) (string, challengeSolver, error) {
+	switch cert.Challenge {
+	case "", ChallengeDNS01:
+		provider, err := newDNSProvider(cert)
+		if err != nil {
+			return "", nil, err
+		}
+		return ChallengeDNS01, &dnsSolver{provider: provider, cert: cert}, nil
+	case ChallengeHTTP01:
+		if a.http01 == nil {
+			return "", nil, fmt.Errorf("HTTP-01验证服务未启用")
+		}
+		return ChallengeHTTP01, &httpSolver{responder: a.http01}, nil
+	case ChallengeTLSALPN01:
+		if a.tlsALPN01 == nil {
+			return "", nil, fmt.Errorf("TLS-ALPN-01验证服务未启用")
+		}
+		return ChallengeTLSALPN01, &tlsALPNSolver{responder: a.tlsALPN01}, nil
+	default:
+		return "", nil, fmt.Errorf("不支持的验证方式 %q", cert.Challenge)
+	}
+}
+
+// dnsSolver 通过DNS提供商添加 _acme-challenge TXT 记录完成dns-01验证，配置了别名时写入别名记录
+type dnsSolver struct {
+	provider DNSProvider
+	cert     *models.Certificate
+	records  []txtRecord
+}
+
+func (s *dnsSolver) Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return fmt.Errorf("计算域名 %s 的验证记录失败: %v", domain, err)
+	}
+
+	fqdn := challengeRecordName(s.cert, domain)
+	utils.InfoLog("为域名 %s 添加TXT记录 %s", domain, fqdn)
+	if err := s.provider.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	s.records = append(s.records, txtRecord{fqdn: fqdn, value: value})
+	return nil
+}
+
+// Wait 等待记录在所有权威服务器上生效，结果记录到证书中供状态展示
+// 无法确定权威服务器时（例如集群内无法访问外部DNS）退回到固定等待DNSSleep
+func (s *dnsSolver) Wait(ctx context.Context) error {
+	checker, err := newPropagationChecker(s.cert)
+	if err != nil {
+		return err
+	}
+
+	utils.InfoLog("检查验证记录是否已在所有权威服务器上生效")
+	results, err := checker.Wait(ctx, s.records)
+	s.cert.Propagation = results
+	if err == nil || results != nil || ctx.Err() != nil {
+		return err
+	}
+
+	utils.WarningLog("无法检查验证记录的生效情况: %v", err)
+	utils.InfoLog("等待 %s 让DNS记录生效", DNSSleep)
+	select {
+	case <-time.After(DNSSleep):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *dnsSolver) CleanUp(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	return s.provider.CleanUp(ctx, challengeRecordName(s.cert, domain), value)
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 保存进行中验证内容的Secret，位于 POD_NAMESPACE；多副本部署时未持有租约的副本从这里读取验证内容响应CA
+	ChallengeSecretName = getEnvOrDefault("CHALLENGE_SECRET_NAME", "autocert-challenges")
+	// 通知CA验证前等待验证内容到达监听缓存的最长时间
+	ChallengeSyncTimeout = getDurationFromEnv("CHALLENGE_SYNC_TIMEOUT", 30*time.Second)
+)
+
+// ChallengeStore 将HTTP-01和TLS-ALPN-01的验证内容同步到Secret，两个验证服务共用同一个实例
+// Service会把CA的验证请求转发到任意副本，只有leader布置验证，其他副本在本地没有记录时从该Secret的监听缓存中查找
+type ChallengeStore struct {
+	clientset kubernetes.Interface
+	mu        sync.Mutex // 同一副本内串行修改Secret
+
+	// Start同步完成后由informer维护的Secret缓存，响应验证请求时只读缓存，不访问API Server
+	cacheMu sync.RWMutex
+	cache   cache.Store
+}
+
+func NewChallengeStore(clientset kubernetes.Interface) *ChallengeStore {
+	utils.DebugLog("创建验证内容存储")
+	return &ChallengeStore{clientset: clientset}
+}
+
+// put 写入一项验证内容，Secret不存在时创建
+func (s *ChallengeStore) put(ctx context.Context, key string, value []byte) error {
+	return s.update(ctx, func(data map[string][]byte) bool {
+		data[key] = value
+		return true
+	})
+}
+
+// remove 删除一项验证内容
+func (s *ChallengeStore) remove(ctx context.Context, key string) error {
+	return s.update(ctx, func(data map[string][]byte) bool {
+		if _, ok := data[key]; !ok {
+			return false
+		}
+		delete(data, key)
+		return true
+	})
+}
+
+// Start 开始监听验证Secret，缓存同步后才从中读取其他副本布置的验证内容，ctx结束时停止
+// 同步前验证服务照常监听，只响应本副本布置的验证；API Server不可用或权限不足时一直等待同步
+func (s *ChallengeStore) Start(ctx context.Context) error {
+	if s.clientset == nil {
+		return nil
+	}
+	utils.DebugLog("开始监听验证Secret %s/%s", PodNamespace, ChallengeSecretName)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(s.clientset, 0,
+		informers.WithNamespace(PodNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", ChallengeSecretName).String()
+		}),
+	)
+	informer := factory.Core().V1().Secrets().Informer()
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("等待验证Secret %s/%s 缓存同步失败", PodNamespace, ChallengeSecretName)
+	}
+
+	s.cacheMu.Lock()
+	s.cache = informer.GetStore()
+	s.cacheMu.Unlock()
+	utils.InfoLog("验证Secret %s/%s 缓存已同步", PodNamespace, ChallengeSecretName)
+	return nil
+}
+
+// get 从监听缓存中读取一项验证内容，缓存未同步、Secret或键不存在时返回false
+func (s *ChallengeStore) get(key string) ([]byte, bool) {
+	s.cacheMu.RLock()
+	store := s.cache
+	s.cacheMu.RUnlock()
+	if store == nil {
+		return nil, false
+	}
+	obj, exists, err := store.GetByKey(PodNamespace + "/" + ChallengeSecretName)
+	if err != nil || !exists {
+		return nil, false
+	}
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return nil, false
+	}
+	value, ok := secret.Data[key]
+	return value, ok
+}
+
+// waitServed 等待本副本的监听缓存读到key对应的value，未使用Secret共享时直接返回
+// 所有副本通过API Server的watch接收同一个Secret版本，本副本的缓存读到新内容说明该版本已经分发给各副本的监听
+func (s *ChallengeStore) waitServed(ctx context.Context, key string, value []byte) error {
+	if s.clientset == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, ChallengeSyncTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if served, ok := s.get(key); ok && bytes.Equal(served, value) {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("等待验证Secret %s/%s 中的 %s 同步到监听缓存超时", PodNamespace, ChallengeSecretName, key)
+		}
+	}
+}
+
+// update 读取Secret并用mutate修改数据，mutate返回false表示无需写回；冲突时重新读取后重试
+func (s *ChallengeStore) update(ctx context.Context, mutate func(data map[string][]byte) bool) error {
+	if s.clientset == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets := s.clientset.CoreV1().Secrets(PodNamespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, ChallengeSecretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			data := make(map[string][]byte)
+			if !mutate(data) {
+				return nil
+			}
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ChallengeSecretName,
+					Namespace: PodNamespace,
+					Labels: map[string]string{
+						"app.kubernetes.io/managed-by": "autocert",
+					},
+				},
+				Type: corev1.SecretTypeOpaque,
+				Data: data,
+			}
+			_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// 按冲突处理，重新读取后修改
+				return apierrors.NewConflict(corev1.Resource("secrets"), ChallengeSecretName, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		if !mutate(secret.Data) {
+			return nil
+		}
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("更新验证Secret %s/%s 失败: %v", PodNamespace, ChallengeSecretName, err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestChallengeStoreServesFromCache 待命副本从监听缓存中响应其他副本布置的验证，请求路径上不访问API Server
+func TestChallengeStoreServesFromCache(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader := NewHTTP01Responder(clientset, NewChallengeStore(clientset))
+	if err := leader.Present(ctx, "example.test", "before-start", "before-start.thumbprint"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 待命副本的两个验证服务共用一个存储，只建立一次监听
+	store := NewChallengeStore(clientset)
+	standby := NewHTTP01Responder(clientset, store)
+	NewTLSALPN01Responder(store)
+	if err := store.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 等待informer开始监听，之后的修改通过watch到达缓存
+	waitFor(t, func() bool {
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "watch" && action.GetResource().Resource == "secrets" {
+				return true
+			}
+		}
+		return false
+	})
+	lists := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "secrets" {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("listed the challenge secret %d times, want 1", lists)
+	}
+	if err := leader.Present(ctx, "example.test", "after-start", "after-start.thumbprint"); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(token string) (int, string) {
+		rec := httptest.NewRecorder()
+		standby.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, http01PathPrefix+token, nil))
+		return rec.Code, rec.Body.String()
+	}
+	waitFor(t, func() bool {
+		code, _ := serve("after-start")
+		return code == http.StatusOK
+	})
+
+	clientset.ClearActions()
+	for token, want := range map[string]string{
+		"before-start": "before-start.thumbprint",
+		"after-start":  "after-start.thumbprint",
+	} {
+		if code, body := serve(token); code != http.StatusOK || body != want {
+			t.Errorf("token %s: %d %q, want %q", token, code, body, want)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if code, _ := serve("unknown"); code != http.StatusNotFound {
+			t.Fatalf("unknown token: %d, want 404", code)
+		}
+	}
+	for _, action := range clientset.Actions() {
+		t.Errorf("request path called the API: %s %s", action.GetVerb(), action.GetResource().Resource)
+	}
+
+	// 清理后缓存中的令牌随之删除
+	if err := leader.CleanUp(ctx, "example.test", "after-start"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		code, _ := serve("after-start")
+		return code == http.StatusNotFound
+	})
+}
+
+// TestChallengeStoreNotSynced 缓存同步前验证服务照常响应本副本的令牌，其他副本的令牌返回404
+func TestChallengeStoreNotSynced(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader := NewHTTP01Responder(clientset, NewChallengeStore(clientset))
+	if err := leader.Present(ctx, "example.test", "shared", "shared.thumbprint"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟响应缓慢的API Server，列出Secret的请求在release关闭前不返回
+	release := make(chan struct{})
+	clientset.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		<-release
+		return false, nil, nil
+	})
+	store := NewChallengeStore(clientset)
+	standby := NewHTTP01Responder(clientset, store)
+	started := make(chan error, 1)
+	go func() { started <- store.Start(ctx) }()
+
+	if err := standby.Present(ctx, "local.test", "local", "local.thumbprint"); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(token string) int {
+		rec := httptest.NewRecorder()
+		standby.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, http01PathPrefix+token, nil))
+		return rec.Code
+	}
+	if code := serve("local"); code != http.StatusOK {
+		t.Errorf("local token before sync: %d, want 200", code)
+	}
+	if code := serve("shared"); code != http.StatusNotFound {
+		t.Errorf("shared token before sync: %d, want 404", code)
+	}
+
+	close(release)
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+	if code := serve("shared"); code != http.StatusOK {
+		t.Errorf("shared token after sync: %d, want 200", code)
+	}
+}
+
+// TestSolverWaitsForSharedChallenge 布置验证的副本在自己的监听缓存读到验证内容后才通知CA验证
+func TestSolverWaitsForSharedChallenge(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// leader的监听由测试控制，模拟watch事件延迟到达；其他副本使用正常的监听
+	delayed := watch.NewFake()
+	watches := 0
+	clientset.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watches++
+		return watches == 1, delayed, nil
+	})
+	leaderStore, standbyStore := NewChallengeStore(clientset), NewChallengeStore(clientset)
+	for _, store := range []*ChallengeStore{leaderStore, standbyStore} {
+		if err := store.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key}
+	solver := &tlsALPNSolver{responder: NewTLSALPN01Responder(leaderStore)}
+	if err := solver.Present(ctx, client, "Example.test", &acme.Challenge{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- solver.Wait(ctx) }()
+
+	storeKey := tlsALPN01StoreKey("example.test")
+	waitFor(t, func() bool {
+		_, ok := standbyStore.get(storeKey)
+		return ok
+	})
+	select {
+	case err := <-done:
+		t.Fatalf("Wait returned before the leader's cache served the challenge: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	secret, err := clientset.CoreV1().Secrets(PodNamespace).Get(ctx, ChallengeSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delayed.Add(secret)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after the leader's cache served the challenge")
+	}
+	leaderValue, _ := leaderStore.get(storeKey)
+	standbyValue, _ := standbyStore.get(storeKey)
+	if string(leaderValue) != string(standbyValue) {
+		t.Error("leader and standby caches serve different challenge certificates")
+	}
+}
+
+// waitFor 轮询cond直到返回true，超时后测试失败
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
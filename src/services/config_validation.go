@@ -0,0 +1,460 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// acme.sh dnsapi 脚本名，例如 dns_cf、dns_ali
+	acmeShProviderPattern = regexp.MustCompile(`^dns_[a-z0-9_]+$`)
+	// 账户名会作为Secret名称的一部分
+	accountNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// FieldError 一条配置校验错误，Line为配置文件中的行号，未知时为0
+type FieldError struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors 配置中的全部校验错误
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ParseConfig 严格解析证书配置文件：拒绝未知字段，并校验签发者和每个证书
+// 返回的错误带有行号，校验失败时错误为*ConfigError
+func ParseConfig(data []byte) (*models.Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+
+	var config models.Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, &ConfigError{Err: err}
+	}
+
+	v := &configValidator{root: &root}
+	v.validateConfig(&config)
+	if len(v.errs) > 0 {
+		return nil, &ConfigError{Err: v.errs}
+	}
+	return &config, nil
+}
+
+// ValidateCertificate 校验单个证书，用于不经过配置文件的证书资源；issuerType为证书所用签发者的类型
+func ValidateCertificate(cert *models.Certificate, issuerType string) error {
+	v := &configValidator{}
+	v.validateCertificate(nil, cert, issuerType)
+	if len(v.errs) > 0 {
+		return &ConfigError{Err: v.errs}
+	}
+	return nil
+}
+
+// configValidator 收集校验错误，并通过YAML节点树找到出错字段所在的行
+type configValidator struct {
+	root *yaml.Node
+	errs ValidationErrors
+}
+
+// path 配置中字段的位置，元素为映射键(string)或列表下标(int)
+type path []interface{}
+
+func (p path) child(elems ...interface{}) path {
+	return append(append(path{}, p...), elems...)
+}
+
+func (p path) String() string {
+	var sb strings.Builder
+	for _, elem := range p {
+		switch e := elem.(type) {
+		case int:
+			sb.WriteString("[" + strconv.Itoa(e) + "]")
+		case string:
+			if sb.Len() > 0 {
+				sb.WriteString(".")
+			}
+			sb.WriteString(e)
+		}
+	}
+	return sb.String()
+}
+
+func (v *configValidator) addf(p path, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{
+		Line:    v.line(p),
+		Field:   p.String(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// line 沿路径查找节点的行号，路径中的字段不存在时返回最近的上级节点的行号
+func (v *configValidator) line(p path) int {
+	if v.root == nil {
+		return 0
+	}
+	node := v.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, elem := range p {
+		var next *yaml.Node
+		switch e := elem.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == e {
+						next = node.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && e < len(node.Content) {
+				next = node.Content[e]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return node.Line
+}
+
+func (v *configValidator) validateConfig(config *models.Config) {
+	issuerTypes := map[string]string{DefaultIssuerName: IssuerTypeACME}
+	seenIssuers := make(map[string]bool)
+	for i := range config.Issuers {
+		p := path{"issuers", i}
+		cfg := &config.Issuers[i]
+
+		switch {
+		case cfg.Name == "":
+			v.addf(p.child("name"), "签发者名称不能为空")
+		case seenIssuers[cfg.Name]:
+			v.addf(p.child("name"), "签发者名称 %q 重复", cfg.Name)
+		}
+		seenIssuers[cfg.Name] = true
+
+		switch cfg.Type {
+		case IssuerTypeACME:
+		case IssuerTypeCA:
+			if cfg.CA == nil || cfg.CA.Secret.Name == "" {
+				v.addf(p.child("ca", "secret", "name"), "ca签发者需要指定保存CA证书的Secret")
+			} else if cfg.CA.Duration != "" {
+				if d, err := time.ParseDuration(cfg.CA.Duration); err != nil || d <= 0 {
+					v.addf(p.child("ca", "duration"), "无效的有效期 %q", cfg.CA.Duration)
+				}
+			}
+		default:
+			v.addf(p.child("type"), "未知的签发者类型 %q，可选 %s 或 %s", cfg.Type, IssuerTypeACME, IssuerTypeCA)
+		}
+		if cfg.Name != "" {
+			issuerTypes[cfg.Name] = cfg.Type
+		}
+	}
+
+	accounts := make(map[string]bool)
+	for i := range config.Accounts {
+		p := path{"accounts", i}
+		account := &config.Accounts[i]
+
+		switch {
+		case account.Name == "":
+			v.addf(p.child("name"), "账户名称不能为空")
+		case !accountNamePattern.MatchString(account.Name):
+			v.addf(p.child("name"), "账户名称 %q 只能包含小写字母、数字和 -", account.Name)
+		case strings.HasPrefix(account.Name, implicitAccountPrefix):
+			v.addf(p.child("name"), "账户名称 %q 不能以 %s 开头，该前缀保留给未指定账户的证书", account.Name, implicitAccountPrefix)
+		case accounts[account.Name]:
+			v.addf(p.child("name"), "账户名称 %q 重复", account.Name)
+		}
+		accounts[account.Name] = true
+
+		if _, err := ResolveAcmeServer(account.Server); err != nil {
+			v.addf(p.child("server"), "%v", err)
+		}
+		if account.Email != "" {
+			if _, err := mail.ParseAddress(account.Email); err != nil {
+				v.addf(p.child("email"), "无效的邮箱地址 %q", account.Email)
+			}
+		}
+		switch account.KeyType {
+		case "", AccountKeyEC256, AccountKeyEC384, AccountKeyRSA2048, AccountKeyRSA4096:
+		default:
+			v.addf(p.child("keyType"), "不支持的密钥类型 %q，可选 %s、%s、%s 或 %s", account.KeyType, AccountKeyEC256, AccountKeyEC384, AccountKeyRSA2048, AccountKeyRSA4096)
+		}
+		if account.KeyRevision < 0 {
+			v.addf(p.child("keyRevision"), "不能为负数")
+		}
+		if account.EAB != nil && account.EAB.Name == "" {
+			v.addf(p.child("eab", "name"), "Secret名称不能为空")
+		}
+	}
+
+	seenNames := make(map[string]int)
+	for i := range config.Domains {
+		p := path{"domains", i}
+		cert := &config.Domains[i]
+
+		if cert.Name != "" {
+			// 证书名称是状态ConfigMap中的键，一个无效的键会让整个ConfigMap无法更新
+			if errs := validation.IsConfigMapKey(cert.Name); len(errs) > 0 {
+				v.addf(p.child("name"), "无效的证书名称 %q: %s", cert.Name, strings.Join(errs, "; "))
+			}
+			if first, ok := seenNames[cert.Name]; ok {
+				v.addf(p.child("name"), "证书名称 %q 与 domains[%d] 重复", cert.Name, first)
+			} else {
+				seenNames[cert.Name] = i
+			}
+		}
+
+		issuerName := cert.Issuer
+		if issuerName == "" {
+			issuerName = DefaultIssuerName
+		}
+		issuerType, ok := issuerTypes[issuerName]
+		if !ok {
+			v.addf(p.child("issuer"), "未定义的签发者 %q", cert.Issuer)
+		}
+
+		if cert.Account != "" && !accounts[cert.Account] {
+			v.addf(p.child("account"), "未定义的ACME账户 %q", cert.Account)
+		}
+
+		v.validateCertificate(p, cert, issuerType)
+		for j, ref := range cert.Secrets {
+			if ref.Namespace == "" {
+				v.addf(p.child("secrets", j, "namespace"), "Secret命名空间不能为空")
+			}
+		}
+	}
+}
+
+// validateCertificate 校验证书本身的字段，p为证书在配置中的位置
+func (v *configValidator) validateCertificate(p path, cert *models.Certificate, issuerType string) {
+	if cert.Name == "" {
+		v.addf(p.child("name"), "证书名称不能为空")
+	}
+
+	if len(cert.Domains) == 0 {
+		v.addf(p.child("domains"), "至少需要一个域名")
+	}
+	seenDomains := make(map[string]bool)
+	for j, domain := range cert.Domains {
+		if err := validateDomain(domain); err != nil {
+			v.addf(p.child("domains", j), "无效的域名 %q: %v", domain, err)
+		}
+		if seenDomains[strings.ToLower(domain)] {
+			v.addf(p.child("domains", j), "域名 %q 重复", domain)
+		}
+		seenDomains[strings.ToLower(domain)] = true
+	}
+
+	if len(cert.Secrets) == 0 {
+		v.addf(p.child("secrets"), "至少需要一个保存证书的Secret")
+	}
+	for j, ref := range cert.Secrets {
+		if ref.Name == "" {
+			v.addf(p.child("secrets", j, "name"), "Secret名称不能为空")
+		}
+	}
+	for j, ref := range cert.EnvsFrom {
+		if ref.Name == "" {
+			v.addf(p.child("envsFrom", j, "name"), "Secret名称不能为空")
+		}
+	}
+
+	if cert.RenewBefore != "" {
+		if d, err := ParseRenewBefore(cert.RenewBefore); err != nil || d <= 0 {
+			v.addf(p.child("renewBefore"), "无效的续签时间 %q", cert.RenewBefore)
+		}
+	}
+	if cert.RenewBeforePercentage < 0 || cert.RenewBeforePercentage > 99 {
+		v.addf(p.child("renewBeforePercentage"), "必须在1到99之间")
+	}
+
+	if issuerType == IssuerTypeACME {
+		v.validateACME(p, cert)
+	}
+}
+
+// validateACME 校验ACME签发所需的字段
+func (v *configValidator) validateACME(p path, cert *models.Certificate) {
+	if cert.Account != "" {
+		// 服务器、邮箱和EAB由账户提供
+		for _, field := range []struct {
+			name string
+			set  bool
+		}{{"server", cert.Server != ""}, {"email", cert.Email != ""}, {"eab", cert.EAB != nil}} {
+			if field.set {
+				v.addf(p.child(field.name), "已引用账户 %q，不能再设置%s", cert.Account, field.name)
+			}
+		}
+	} else if _, err := ResolveAcmeServer(cert.Server); err != nil {
+		v.addf(p.child("server"), "%v", err)
+	}
+
+	if cert.EAB != nil && cert.EAB.Name == "" {
+		v.addf(p.child("eab", "name"), "Secret名称不能为空")
+	}
+
+	if cert.Email != "" {
+		if _, err := mail.ParseAddress(cert.Email); err != nil {
+			v.addf(p.child("email"), "无效的邮箱地址 %q", cert.Email)
+		}
+	}
+
+	switch cert.Challenge {
+	case "", ChallengeDNS01:
+		v.validateDNS(p, cert)
+	case ChallengeHTTP01, ChallengeTLSALPN01:
+		for j, domain := range cert.Domains {
+			if strings.HasPrefix(domain, "*.") {
+				v.addf(p.child("domains", j), "通配符域名只能使用%s验证", ChallengeDNS01)
+			}
+		}
+	default:
+		v.addf(p.child("challenge"), "不支持的验证方式 %q，可选 %s、%s 或 %s", cert.Challenge, ChallengeDNS01, ChallengeHTTP01, ChallengeTLSALPN01)
+	}
+}
+
+// validateDNS 校验DNS-01验证的提供商、别名和等待时间
+func (v *configValidator) validateDNS(p path, cert *models.Certificate) {
+	switch {
+	case cert.DNSProvider == "":
+		v.addf(p.child("dns"), "DNS-01验证需要指定DNS提供商")
+	case cert.DNSProvider == DNSProviderRFC2136:
+		if cert.Envs[rfc2136EnvNameserver] == "" && len(cert.EnvsFrom) == 0 {
+			v.addf(p.child("envs"), "rfc2136 需要设置 %s", rfc2136EnvNameserver)
+		}
+	case cert.DNSProvider == DNSProviderWebhook:
+		if cert.Webhook == nil || cert.Webhook.URL == "" {
+			v.addf(p.child("webhook", "url"), "webhook DNS提供商需要指定url")
+		} else {
+			if u, err := url.Parse(cert.Webhook.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				v.addf(p.child("webhook", "url"), "无效的webhook地址 %q", cert.Webhook.URL)
+			}
+			if cert.Webhook.Timeout != "" {
+				if d, err := time.ParseDuration(cert.Webhook.Timeout); err != nil || d <= 0 {
+					v.addf(p.child("webhook", "timeout"), "无效的超时时间 %q", cert.Webhook.Timeout)
+				}
+			}
+		}
+	case !acmeShProviderPattern.MatchString(cert.DNSProvider):
+		v.addf(p.child("dns"), "未知的DNS提供商 %q，可选 %s、%s 或 acme.sh 的 dns_* 脚本名", cert.DNSProvider, DNSProviderRFC2136, DNSProviderWebhook)
+	default:
+		// 只接受镜像中实际存在的dnsapi脚本，拼写错误在加载配置时就能发现
+		if dir := acmeShDNSAPIDir(); dir == "" {
+			utils.WarningLog("找不到acme.sh的dnsapi目录，无法确认DNS提供商 %q 是否存在", cert.DNSProvider)
+		} else if _, err := os.Stat(filepath.Join(dir, cert.DNSProvider+".sh")); err != nil {
+			v.addf(p.child("dns"), "未知的DNS提供商 %q，%s 中没有对应的acme.sh dnsapi脚本", cert.DNSProvider, dir)
+		}
+	}
+
+	for _, field := range []struct {
+		name    string
+		aliases map[string]string
+	}{{"challengeAlias", cert.ChallengeAlias}, {"domainAlias", cert.DomainAlias}} {
+		for domain, alias := range field.aliases {
+			if !containsDomain(cert.Domains, domain) {
+				v.addf(p.child(field.name, domain), "域名 %q 不在domains中", domain)
+			}
+			if err := validateDomain(strings.TrimSuffix(alias, ".")); err != nil || strings.HasPrefix(alias, "*.") {
+				v.addf(p.child(field.name, domain), "无效的别名 %q", alias)
+			}
+		}
+	}
+
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"propagationTimeout", cert.PropagationTimeout}, {"propagationInterval", cert.PropagationInterval}} {
+		if field.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(field.value); err != nil || d <= 0 {
+			v.addf(p.child(field.name), "无效的时间 %q", field.value)
+		}
+	}
+}
+
+// containsDomain 判断别名的键是否对应证书中的域名，*.example.com 与 example.com 视为同一域名
+func containsDomain(domains []string, name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	for _, domain := range domains {
+		if strings.EqualFold(strings.TrimPrefix(domain, "*."), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDomain 检查域名是否为合法的主机名，通配符只能作为最左侧的整个标签
+func validateDomain(domain string) error {
+	name := strings.TrimPrefix(domain, "*.")
+	if strings.Contains(name, "*") {
+		return fmt.Errorf("通配符只能出现在最左侧，形如 *.example.com")
+	}
+	if name == "" {
+		return fmt.Errorf("域名为空")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("域名超过253个字符")
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("包含空标签")
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("标签 %q 超过63个字符", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("标签 %q 不能以 - 开头或结尾", label)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("标签 %q 包含非法字符 %q", label, r)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigCertificateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"example", true},
+		{"example.com_wildcard-1", true},
+		{"Example.COM", true},
+		{"team/example", false},
+		{"example com", false},
+		{"例子", false},
+		{"example:443", false},
+		{"..", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := fmt.Sprintf(`domains:
+  - name: %q
+    domains: [example.com]
+    dns: dns_cf
+    secrets:
+      - namespace: default
+        name: example-tls
+`, tt.name)
+			_, err := ParseConfig([]byte(config))
+			if tt.valid && err != nil {
+				t.Errorf("ParseConfig: %v", err)
+			}
+			if !tt.valid && (err == nil || !strings.Contains(err.Error(), "domains[0].name")) {
+				t.Errorf("ParseConfig error = %v, want an error on domains[0].name", err)
+			}
+		})
+	}
+}
+
+func TestParseConfigDNSProvider(t *testing.T) {
+	dir := t.TempDir()
+	for _, hook := range []string{"dns_cf.sh", "dns_ali.sh"} {
+		if err := os.WriteFile(filepath.Join(dir, hook), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(original string) { AcmeShDNSAPIDir = original }(AcmeShDNSAPIDir)
+	AcmeShDNSAPIDir = dir
+
+	tests := []struct {
+		dns   string
+		valid bool
+	}{
+		{"dns_cf", true},
+		{"dns_ali", true},
+		{"rfc2136", true},
+		{"dns_cloudflare", false},
+		{"dns_", false},
+		{"cloudflare", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dns, func(t *testing.T) {
+			config := fmt.Sprintf(`domains:
+  - name: example
+    domains: [example.com]
+    dns: %q
+    envs:
+      RFC2136_NAMESERVER: ns.example.com
+    secrets:
+      - namespace: default
+        name: example-tls
+`, tt.dns)
+			_, err := ParseConfig([]byte(config))
+			if tt.valid && err != nil {
+				t.Errorf("ParseConfig: %v", err)
+			}
+			if !tt.valid && (err == nil || !strings.Contains(err.Error(), "domains[0].dns")) {
+				t.Errorf("ParseConfig error = %v, want an error on domains[0].dns", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// 检查委托时最多跟随的CNAME跳数
+const maxCNAMEHops = 8
+
+// lookupCNAME 查询名称自身的CNAME记录，只解析一跳，没有CNAME时返回空字符串；可在测试中替换
+var lookupCNAME = queryCNAME
+
+// queryCNAME 通过DNS_RESOLVERS中的递归DNS服务器查询一跳CNAME
+func queryCNAME(ctx context.Context, name string) (string, error) {
+	resolvers, err := dnsResolvers()
+	if err != nil {
+		return "", err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeCNAME)
+	resp, err := queryResolvers(ctx, &dns.Client{Timeout: 5 * time.Second}, resolvers, msg)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, dns.Fqdn(name)) {
+			return strings.TrimSuffix(cname.Target, "."), nil
+		}
+	}
+	return "", nil
+}
+
+// challengeRecordName 返回域名的DNS-01验证记录名
+// 配置了challengeAlias时写入 _acme-challenge.<alias>，配置了domainAlias时直接写入<alias>，
+// 两者都要求 _acme-challenge.<domain> 已通过CNAME指向该记录
+func challengeRecordName(cert *models.Certificate, domain string) string {
+	if alias, ok := lookupAlias(cert.DomainAlias, domain); ok {
+		return alias
+	}
+	if alias, ok := lookupAlias(cert.ChallengeAlias, domain); ok {
+		return "_acme-challenge." + alias
+	}
+	return "_acme-challenge." + domain
+}
+
+// lookupAlias 按域名查找别名，*.example.com 与 example.com 共用同一条验证记录
+func lookupAlias(aliases map[string]string, domain string) (string, bool) {
+	domain = strings.TrimPrefix(domain, "*.")
+	for name, alias := range aliases {
+		if strings.EqualFold(strings.TrimPrefix(name, "*."), domain) && alias != "" {
+			return strings.TrimSuffix(alias, "."), true
+		}
+	}
+	return "", false
+}
+
+// checkChallengeAliases 在下单前检查配置了别名的域名是否已将 _acme-challenge 记录CNAME到别名
+func checkChallengeAliases(ctx context.Context, cert *models.Certificate) error {
+	if len(cert.ChallengeAlias) == 0 && len(cert.DomainAlias) == 0 {
+		return nil
+	}
+
+	for _, domain := range cert.Domains {
+		target := challengeRecordName(cert, domain)
+		name := "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+		if target == name {
+			continue
+		}
+
+		// 逐跳跟随CNAME，委托可能经过中间记录，别名记录本身也可能继续指向DNS服务商的内部记录
+		chain := []string{name}
+		delegated := false
+		for hop := 0; hop < maxCNAMEHops && !delegated; hop++ {
+			next, err := lookupCNAME(ctx, chain[len(chain)-1])
+			if err != nil {
+				return fmt.Errorf("域名 %s 的验证记录委托检查失败，请添加CNAME记录 %s -> %s: %v", domain, name, target, err)
+			}
+			if next == "" {
+				break
+			}
+			chain = append(chain, strings.TrimSuffix(next, "."))
+			delegated = strings.EqualFold(chain[len(chain)-1], target)
+		}
+		if !delegated {
+			current := "没有CNAME记录"
+			if len(chain) > 1 {
+				current = "当前为 " + strings.Join(chain, " -> ")
+			}
+			return fmt.Errorf("域名 %s 的验证记录没有委托到别名，请添加CNAME记录 %s -> %s（%s）", domain, name, target, current)
+		}
+		utils.DebugLog("域名 %s 的验证记录已通过CNAME委托到 %s: %s", domain, target, strings.Join(chain, " -> "))
+	}
+	return nil
+}
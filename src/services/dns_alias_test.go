@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+func TestCheckChallengeAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		cert    models.Certificate
+		cnames  map[string]string // 每个名称的一跳CNAME
+		wantErr string
+	}{
+		{
+			name: "no alias",
+			cert: models.Certificate{Domains: []string{"example.com"}},
+		},
+		{
+			name:   "direct challenge alias",
+			cert:   models.Certificate{Domains: []string{"example.com", "*.example.com"}, ChallengeAlias: map[string]string{"example.com": "alias.net"}},
+			cnames: map[string]string{"_acme-challenge.example.com": "_acme-challenge.alias.net."},
+		},
+		{
+			name:   "domain alias",
+			cert:   models.Certificate{Domains: []string{"example.com"}, DomainAlias: map[string]string{"example.com": "example-com.acme.alias.net"}},
+			cnames: map[string]string{"_acme-challenge.example.com": "example-com.acme.alias.net"},
+		},
+		{
+			name: "alias continues to the provider",
+			cert: models.Certificate{Domains: []string{"example.com"}, ChallengeAlias: map[string]string{"example.com": "alias.net"}},
+			cnames: map[string]string{
+				"_acme-challenge.example.com": "_acme-challenge.alias.net",
+				"_acme-challenge.alias.net":   "records.provider.net",
+			},
+		},
+		{
+			name: "delegation through an intermediate name",
+			cert: models.Certificate{Domains: []string{"example.com"}, ChallengeAlias: map[string]string{"example.com": "alias.net"}},
+			cnames: map[string]string{
+				"_acme-challenge.example.com": "acme.example.com",
+				"acme.example.com":            "_acme-challenge.alias.net",
+			},
+		},
+		{
+			name:    "missing cname",
+			cert:    models.Certificate{Domains: []string{"example.com"}, ChallengeAlias: map[string]string{"example.com": "alias.net"}},
+			wantErr: "没有CNAME记录",
+		},
+		{
+			name: "chain ends in another zone",
+			cert: models.Certificate{Domains: []string{"example.com"}, ChallengeAlias: map[string]string{"example.com": "alias.net"}},
+			cnames: map[string]string{
+				"_acme-challenge.example.com": "_acme-challenge.other.net",
+				"_acme-challenge.other.net":   "records.provider.net",
+			},
+			wantErr: "_acme-challenge.example.com -> _acme-challenge.other.net -> records.provider.net",
+		},
+		{
+			name: "cname loop",
+			cert: models.Certificate{Domains: []string{"example.com"}, ChallengeAlias: map[string]string{"example.com": "alias.net"}},
+			cnames: map[string]string{
+				"_acme-challenge.example.com": "a.example.com",
+				"a.example.com":               "_acme-challenge.example.com",
+			},
+			wantErr: "没有委托到别名",
+		},
+		{
+			name:    "lookup error",
+			cert:    models.Certificate{Domains: []string{"broken.com"}, ChallengeAlias: map[string]string{"broken.com": "alias.net"}},
+			wantErr: "委托检查失败",
+		},
+	}
+
+	defer func(original func(context.Context, string) (string, error)) { lookupCNAME = original }(lookupCNAME)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries []string
+			lookupCNAME = func(ctx context.Context, name string) (string, error) {
+				queries = append(queries, name)
+				if strings.HasSuffix(name, "broken.com") {
+					return "", errors.New("SERVFAIL")
+				}
+				return tt.cnames[name], nil
+			}
+
+			err := checkChallengeAliases(context.Background(), &tt.cert)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkChallengeAliases: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("checkChallengeAliases error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if len(queries) > maxCNAMEHops*len(tt.cert.Domains) {
+				t.Errorf("made %d CNAME queries", len(queries))
+			}
+			if tt.cert.ChallengeAlias == nil && tt.cert.DomainAlias == nil && len(queries) > 0 {
+				t.Errorf("queried %v without aliases", queries)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 等待验证记录在所有权威服务器上生效的默认最长时间和检查间隔，可按证书覆盖
+	DNSPropagationTimeout  = getDurationFromEnv("DNS_PROPAGATION_TIMEOUT", 10*time.Minute)
+	DNSPropagationInterval = getDurationFromEnv("DNS_PROPAGATION_INTERVAL", 10*time.Second)
+	// 查找区域和权威服务器使用的递归DNS服务器，逗号分隔，为空时使用 /etc/resolv.conf
+	DNSResolvers = getEnvOrDefault("DNS_RESOLVERS", "")
+)
+
+// txtRecord 一条已布置的验证记录
+type txtRecord struct {
+	fqdn  string
+	value string
+}
+
+// propagationChecker 直接查询区域的权威服务器，确认验证记录已经生效
+type propagationChecker struct {
+	resolvers []string
+	timeout   time.Duration
+	interval  time.Duration
+	client    *dns.Client
+}
+
+// newPropagationChecker 按证书配置创建检查器，未配置的项使用全局默认值
+func newPropagationChecker(cert *models.Certificate) (*propagationChecker, error) {
+	c := &propagationChecker{
+		timeout:  DNSPropagationTimeout,
+		interval: DNSPropagationInterval,
+		client:   &dns.Client{Timeout: 5 * time.Second},
+	}
+
+	if cert.PropagationTimeout != "" {
+		d, err := time.ParseDuration(cert.PropagationTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("证书 %s 的propagationTimeout无效: %v", cert.Name, err)
+		}
+		c.timeout = d
+	}
+	if cert.PropagationInterval != "" {
+		d, err := time.ParseDuration(cert.PropagationInterval)
+		if err != nil {
+			return nil, fmt.Errorf("证书 %s 的propagationInterval无效: %v", cert.Name, err)
+		}
+		c.interval = d
+	}
+	return c, nil
+}
+
+// dnsResolvers 返回递归DNS服务器列表
+func dnsResolvers() ([]string, error) {
+	var servers []string
+	if DNSResolvers != "" {
+		servers = strings.Split(DNSResolvers, ",")
+	} else {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, fmt.Errorf("读取 /etc/resolv.conf 失败: %v", err)
+		}
+		servers = conf.Servers
+	}
+
+	var resolvers []string
+	for _, s := range servers {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		resolvers = append(resolvers, s)
+	}
+	if len(resolvers) == 0 {
+		return nil, fmt.Errorf("没有可用的DNS服务器")
+	}
+	return resolvers, nil
+}
+
+// Wait 轮询所有记录所在区域的权威服务器，直到每台服务器都返回期望的TXT值或超时
+// 返回每台服务器上每条记录的生效情况；无法确定权威服务器时返回nil结果和错误
+func (c *propagationChecker) Wait(ctx context.Context, records []txtRecord) ([]models.NameserverPropagation, error) {
+	type check struct {
+		record     txtRecord
+		nameserver string
+		address    string
+	}
+
+	resolvers, err := dnsResolvers()
+	if err != nil {
+		return nil, err
+	}
+	c.resolvers = resolvers
+
+	// 每条记录对应其区域的全部权威服务器
+	var checks []check
+	for _, r := range records {
+		servers, err := c.authoritativeNameservers(ctx, r.fqdn)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range servers {
+			checks = append(checks, check{record: r, nameserver: ns.name, address: ns.address})
+		}
+	}
+
+	start := time.Now()
+	results := make([]models.NameserverPropagation, len(checks))
+	for i, chk := range checks {
+		results[i] = models.NameserverPropagation{FQDN: chk.record.fqdn, Nameserver: chk.nameserver}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	for {
+		pending := 0
+		for i, chk := range checks {
+			if results[i].Ready {
+				continue
+			}
+			found, err := c.hasTXT(ctx, chk.address, chk.record.fqdn, chk.record.value)
+			if err != nil {
+				utils.DebugLog("查询权威服务器 %s 上的记录 %s 失败: %v", chk.nameserver, chk.record.fqdn, err)
+			}
+			if found {
+				results[i].Ready = true
+				results[i].Duration = time.Since(start).Round(time.Second).String()
+				utils.InfoLog("权威服务器 %s 上的记录 %s 已在 %s 后生效", chk.nameserver, chk.record.fqdn, results[i].Duration)
+				continue
+			}
+			pending++
+		}
+		if pending == 0 {
+			return results, nil
+		}
+
+		select {
+		case <-time.After(c.interval):
+		case <-ctx.Done():
+			elapsed := time.Since(start).Round(time.Second).String()
+			var lagging []string
+			for i := range results {
+				if !results[i].Ready {
+					results[i].Duration = elapsed
+					lagging = append(lagging, fmt.Sprintf("%s(%s)", results[i].Nameserver, results[i].FQDN))
+				}
+			}
+			utils.WarningLog("以下权威服务器在 %s 内没有返回验证记录: %s", elapsed, strings.Join(lagging, ", "))
+			return results, fmt.Errorf("验证记录在 %s 内没有在所有权威服务器上生效，未生效: %s", elapsed, strings.Join(lagging, ", "))
+		}
+	}
+}
+
+type nameserver struct {
+	name    string
+	address string
+}
+
+// authoritativeNameservers 查找记录所在区域的权威服务器及其地址
+func (c *propagationChecker) authoritativeNameservers(ctx context.Context, fqdn string) ([]nameserver, error) {
+	zone, err := c.findZone(ctx, fqdn)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(zone, dns.TypeNS)
+	resp, err := c.query(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("查询区域 %s 的NS记录失败: %v", zone, err)
+	}
+
+	var names []string
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			names = append(names, strings.ToLower(ns.Ns))
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("区域 %s 没有NS记录", zone)
+	}
+	sort.Strings(names)
+
+	// NS响应的附加部分可能带有权威服务器的地址
+	glue := map[string]string{}
+	for _, rr := range resp.Extra {
+		switch rr := rr.(type) {
+		case *dns.A:
+			glue[strings.ToLower(rr.Hdr.Name)] = rr.A.String()
+		case *dns.AAAA:
+			if _, ok := glue[strings.ToLower(rr.Hdr.Name)]; !ok {
+				glue[strings.ToLower(rr.Hdr.Name)] = rr.AAAA.String()
+			}
+		}
+	}
+
+	var servers []nameserver
+	for _, name := range names {
+		addr, ok := glue[name]
+		if !ok {
+			if addr, err = c.lookupAddress(ctx, name); err != nil {
+				return nil, fmt.Errorf("解析权威服务器 %s 的地址失败: %v", name, err)
+			}
+		}
+		servers = append(servers, nameserver{name: strings.TrimSuffix(name, "."), address: net.JoinHostPort(addr, "53")})
+	}
+	utils.DebugLog("记录 %s 所在区域 %s 的权威服务器: %v", fqdn, zone, names)
+	return servers, nil
+}
+
+// lookupAddress 通过递归DNS服务器查询主机的地址，优先使用IPv4
+func (c *propagationChecker) lookupAddress(ctx context.Context, host string) (string, error) {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(host), qtype)
+		resp, err := c.query(ctx, msg)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range resp.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				return rr.A.String(), nil
+			case *dns.AAAA:
+				return rr.AAAA.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("没有A或AAAA记录")
+}
+
+// findZone 通过SOA查询确定记录所在的区域
+func (c *propagationChecker) findZone(ctx context.Context, fqdn string) (string, error) {
+	fqdn = dns.Fqdn(fqdn)
+	labels := dns.SplitDomainName(fqdn)
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		msg := new(dns.Msg)
+		msg.SetQuestion(candidate, dns.TypeSOA)
+		resp, err := c.query(ctx, msg)
+		if err != nil {
+			return "", fmt.Errorf("查询 %s 的SOA记录失败: %v", candidate, err)
+		}
+
+		// 记录名不存在或没有SOA时，响应的授权部分通常带有所在区域的SOA
+		for _, rr := range append(resp.Answer, resp.Ns...) {
+			if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, fqdn) {
+				return strings.ToLower(soa.Hdr.Name), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("无法确定记录 %s 所在的区域", fqdn)
+}
+
+// query 依次向递归DNS服务器发送查询
+func (c *propagationChecker) query(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	return queryResolvers(ctx, c.client, c.resolvers, msg)
+}
+
+// queryResolvers 依次向递归DNS服务器发送查询，返回第一个成功或名称不存在的响应
+func queryResolvers(ctx context.Context, client *dns.Client, resolvers []string, msg *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for _, resolver := range resolvers {
+		resp, _, err := client.ExchangeContext(ctx, msg, resolver)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s returned %s", resolver, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// hasTXT 直接向权威服务器查询TXT记录，检查是否包含期望的值
+func (c *propagationChecker) hasTXT(ctx context.Context, address, fqdn, value string) (bool, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+	msg.RecursionDesired = false
+
+	resp, _, err := c.client.ExchangeContext(ctx, msg, address)
+	if err != nil {
+		return false, err
+	}
+	if resp.Truncated {
+		tcp := &dns.Client{Net: "tcp", Timeout: c.client.Timeout}
+		if resp, _, err = tcp.ExchangeContext(ctx, msg, address); err != nil {
+			return false, err
+		}
+	}
+
+	for _, rr := range resp.Answer {
+		if txt, ok := rr.(*dns.TXT); ok && strings.Join(txt.Txt, "") == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
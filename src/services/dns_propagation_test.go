@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startResolver 在本地UDP端口上启动只返回固定记录的递归DNS服务器，返回地址和收到的查询
+func startResolver(t *testing.T, records []string) (string, func() []string) {
+	t.Helper()
+	var rrs []dns.RR
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	var mu sync.Mutex
+	var queries []string
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		q := r.Question[0]
+		mu.Lock()
+		queries = append(queries, dns.TypeToString[q.Qtype]+" "+q.Name)
+		mu.Unlock()
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		for _, rr := range rrs {
+			if rr.Header().Name == q.Name && rr.Header().Rrtype == q.Qtype {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		// NS查询在附加部分返回权威服务器的胶水记录
+		if q.Qtype == dns.TypeNS {
+			for _, ans := range resp.Answer {
+				for _, rr := range rrs {
+					if ns, ok := ans.(*dns.NS); ok && rr.Header().Name == ns.Ns && rr.Header().Rrtype == dns.TypeA && rr.Header().Ttl == 1 {
+						resp.Extra = append(resp.Extra, rr)
+					}
+				}
+			}
+		}
+		w.WriteMsg(resp)
+	})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), queries...)
+	}
+}
+
+func TestAuthoritativeNameservers(t *testing.T) {
+	// TTL为1的A记录作为胶水记录随NS响应返回，其余地址需要单独查询
+	addr, queries := startResolver(t, []string{
+		"example.test. 60 IN SOA ns1.example.test. admin.example.test. 1 3600 600 86400 60",
+		"example.test. 60 IN NS ns1.example.test.",
+		"example.test. 60 IN NS ns2.dns.test.",
+		"example.test. 60 IN NS ns3.dns.test.",
+		"ns1.example.test. 1 IN A 192.0.2.1",
+		"ns2.dns.test. 60 IN A 192.0.2.2",
+		"ns3.dns.test. 60 IN AAAA 2001:db8::3",
+	})
+	c := &propagationChecker{resolvers: []string{addr}, client: &dns.Client{Timeout: time.Second}}
+
+	servers, err := c.authoritativeNameservers(context.Background(), "_acme-challenge.www.example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []nameserver{
+		{name: "ns1.example.test", address: "192.0.2.1:53"},
+		{name: "ns2.dns.test", address: "192.0.2.2:53"},
+		{name: "ns3.dns.test", address: "[2001:db8::3]:53"},
+	}
+	if len(servers) != len(want) {
+		t.Fatalf("nameservers = %v, want %v", servers, want)
+	}
+	for i := range want {
+		if servers[i] != want[i] {
+			t.Errorf("nameserver %d = %v, want %v", i, servers[i], want[i])
+		}
+	}
+
+	for _, q := range queries() {
+		if q == "A ns1.example.test." || q == "AAAA ns1.example.test." {
+			t.Errorf("looked up %s although the NS response had glue", q)
+		}
+	}
+
+	if _, err := c.authoritativeNameservers(context.Background(), "missing.test"); err == nil {
+		t.Error("found nameservers for a zone the resolver does not know")
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 调用acme.sh dnsapi脚本的包装脚本
+	DNSHookPath = getEnvOrDefault("DNS_HOOK_PATH", "/root/dns-hook.sh")
+	// acme.sh dnsapi脚本所在目录，为空时依次查找 $LE_WORKING_DIR/dnsapi 和 /root/.acme.sh/dnsapi
+	AcmeShDNSAPIDir = getEnvOrDefault("ACME_SH_DNSAPI_DIR", "")
+)
+
+// DNSProvider 负责为DNS-01验证添加和删除TXT记录
+type DNSProvider interface {
+	// Present 添加TXT记录
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp 删除Present添加的TXT记录
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// newDNSProvider 根据证书配置创建DNS提供商
+func newDNSProvider(cert *models.Certificate) (DNSProvider, error) {
+	if cert.DNSProvider == "" {
+		return nil, fmt.Errorf("证书 %s 没有配置DNS提供商", cert.Name)
+	}
+
+	switch cert.DNSProvider {
+	case DNSProviderRFC2136:
+		return newRFC2136Provider(cert.DNSEnvs())
+	case DNSProviderWebhook:
+		return newWebhookProvider(cert)
+	default:
+		return &acmeShHookProvider{name: cert.DNSProvider, envs: cert.DNSEnvs()}, nil
+	}
+}
+
+// acmeShDNSAPIDir 返回镜像中acme.sh的dnsapi目录，找不到时返回空字符串
+func acmeShDNSAPIDir() string {
+	candidates := []string{AcmeShDNSAPIDir}
+	if AcmeShDNSAPIDir == "" {
+		candidates = []string{"/root/.acme.sh/dnsapi"}
+		if dir := os.Getenv("LE_WORKING_DIR"); dir != "" {
+			candidates = append([]string{filepath.Join(dir, "dnsapi")}, candidates...)
+		}
+	}
+	for _, dir := range candidates {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// acmeShHookProvider 通过acme.sh自带的dnsapi脚本（如dns_cf、dns_ali）操作TXT记录
+type acmeShHookProvider struct {
+	name string
+	envs map[string]string
+}
+
+func (p *acmeShHookProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "add", fqdn, value)
+}
+
+func (p *acmeShHookProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "rm", fqdn, value)
+}
+
+// run 执行 dns-hook.sh <add|rm> <provider> <fqdn> <value>
+func (p *acmeShHookProvider) run(ctx context.Context, action, fqdn, value string) error {
+	cmd := exec.CommandContext(ctx, DNSHookPath, action, p.name, fqdn, value)
+	cmd.Env = os.Environ()
+	for key, value := range p.envs {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+		utils.DebugLog("环境变量: %s=***", key) // 不打印实际值，保护隐私数据
+	}
+
+	utils.DebugLog("执行DNS脚本: %s %s %s %s", DNSHookPath, action, p.name, fqdn)
+	output, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			utils.InfoLog("%s输出: %s", p.name, line)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s_%s 执行失败: %v: %s", p.name, action, err, lastLine(string(output)))
+	}
+	return nil
+}
+
+// lastLine 返回输出中最后一行非空内容，通常是脚本给出的错误原因
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"me.sttot/auto-cert/src/utils"
+)
+
+// DNSProviderRFC2136 通过RFC2136动态更新操作TXT记录的内置DNS提供商
+const DNSProviderRFC2136 = "rfc2136"
+
+// rfc2136 提供商从证书的envs读取的配置
+const (
+	rfc2136EnvNameserver    = "RFC2136_NAMESERVER"     // 接受动态更新的服务器，host[:port]
+	rfc2136EnvTSIGKey       = "RFC2136_TSIG_KEY"       // TSIG密钥名称，为空时不签名
+	rfc2136EnvTSIGSecret    = "RFC2136_TSIG_SECRET"    // Base64编码的TSIG密钥
+	rfc2136EnvTSIGAlgorithm = "RFC2136_TSIG_ALGORITHM" // 默认 hmac-sha256
+	rfc2136EnvZone          = "RFC2136_ZONE"           // 记录所在的区域，为空时通过SOA查询确定
+	rfc2136EnvTTL           = "RFC2136_TTL"            // TXT记录的TTL，默认60秒
+)
+
+// rfc2136Provider 使用TSIG签名的RFC2136动态更新添加和删除TXT记录，适用于BIND、PowerDNS等
+type rfc2136Provider struct {
+	nameserver    string
+	tsigKey       string
+	tsigSecret    string
+	tsigAlgorithm string
+	zone          string
+	ttl           uint32
+	timeout       time.Duration
+}
+
+// newRFC2136Provider 从证书的envs创建rfc2136提供商
+func newRFC2136Provider(envs map[string]string) (*rfc2136Provider, error) {
+	nameserver := envs[rfc2136EnvNameserver]
+	if nameserver == "" {
+		return nil, fmt.Errorf("rfc2136: %s is required", rfc2136EnvNameserver)
+	}
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	p := &rfc2136Provider{
+		nameserver:    nameserver,
+		tsigKey:       envs[rfc2136EnvTSIGKey],
+		tsigSecret:    envs[rfc2136EnvTSIGSecret],
+		tsigAlgorithm: envs[rfc2136EnvTSIGAlgorithm],
+		zone:          envs[rfc2136EnvZone],
+		ttl:           60,
+		timeout:       10 * time.Second,
+	}
+
+	if (p.tsigKey == "") != (p.tsigSecret == "") {
+		return nil, fmt.Errorf("rfc2136: %s and %s must be set together", rfc2136EnvTSIGKey, rfc2136EnvTSIGSecret)
+	}
+	if p.tsigKey != "" {
+		p.tsigKey = dns.Fqdn(p.tsigKey)
+	}
+	if p.tsigAlgorithm == "" {
+		p.tsigAlgorithm = dns.HmacSHA256
+	}
+	p.tsigAlgorithm = dns.Fqdn(p.tsigAlgorithm)
+	if p.zone != "" {
+		p.zone = dns.Fqdn(p.zone)
+	}
+	if ttl := envs[rfc2136EnvTTL]; ttl != "" {
+		n, err := strconv.ParseUint(ttl, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136: invalid %s %q", rfc2136EnvTTL, ttl)
+		}
+		p.ttl = uint32(n)
+	}
+
+	return p, nil
+}
+
+func (p *rfc2136Provider) Present(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, true)
+}
+
+func (p *rfc2136Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, false)
+}
+
+// update 发送添加或删除一条TXT记录的动态更新
+func (p *rfc2136Provider) update(ctx context.Context, fqdn, value string, add bool) error {
+	fqdn = dns.Fqdn(fqdn)
+	zone, err := p.findZone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: p.ttl},
+		Txt: []string{value},
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(zone)
+	if add {
+		msg.Insert([]dns.RR{rr})
+	} else {
+		msg.Remove([]dns.RR{rr})
+	}
+
+	action := "添加"
+	if !add {
+		action = "删除"
+	}
+	utils.DebugLog("通过RFC2136在区域 %s 中%sTXT记录 %s", zone, action, fqdn)
+
+	resp, err := p.exchange(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("rfc2136: update %s: %v", fqdn, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136: update %s: server returned %s", fqdn, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// findZone 返回记录所在的区域，未配置时向服务器查询SOA，从记录名逐级向上查找
+func (p *rfc2136Provider) findZone(ctx context.Context, fqdn string) (string, error) {
+	if p.zone != "" {
+		return p.zone, nil
+	}
+
+	labels := dns.SplitDomainName(fqdn)
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		msg := new(dns.Msg)
+		msg.SetQuestion(candidate, dns.TypeSOA)
+		resp, err := p.exchange(ctx, msg)
+		if err != nil {
+			return "", fmt.Errorf("rfc2136: query SOA of %s: %v", candidate, err)
+		}
+		for _, rr := range resp.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, candidate) {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("rfc2136: no zone found for %s on %s", fqdn, p.nameserver)
+}
+
+// exchange 发送DNS消息，配置了TSIG时对消息签名
+func (p *rfc2136Provider) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: "tcp", Timeout: p.timeout}
+	if p.tsigKey != "" {
+		client.TsigSecret = map[string]string{p.tsigKey: p.tsigSecret}
+		msg.SetTsig(p.tsigKey, p.tsigAlgorithm, 300, time.Now().Unix())
+	}
+
+	resp, _, err := client.ExchangeContext(ctx, msg, p.nameserver)
+	return resp, err
+}
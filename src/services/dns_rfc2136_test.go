@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	testTSIGKey    = "acme-update."
+	testTSIGSecret = "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+)
+
+// updateServer 只接受TSIG签名消息的权威服务器，保存区域 example.test 中的TXT记录
+type updateServer struct {
+	mu      sync.Mutex
+	txt     map[string][]string
+	updates int
+}
+
+func (s *updateServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+
+	tsig := r.IsTsig()
+	if tsig == nil || w.TsigStatus() != nil {
+		resp.Rcode = dns.RcodeNotAuth
+		w.WriteMsg(resp)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Opcode {
+	case dns.OpcodeQuery:
+		if q := r.Question[0]; q.Qtype == dns.TypeSOA && strings.EqualFold(q.Name, "example.test.") {
+			resp.Answer = append(resp.Answer, &dns.SOA{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+				Ns:  "ns.example.test.", Mbox: "admin.example.test.", Serial: 1,
+			})
+		}
+	case dns.OpcodeUpdate:
+		if !strings.EqualFold(r.Question[0].Name, "example.test.") {
+			resp.Rcode = dns.RcodeNotZone
+			break
+		}
+		s.updates++
+		for _, rr := range r.Ns {
+			txt, ok := rr.(*dns.TXT)
+			if !ok {
+				continue
+			}
+			name := strings.ToLower(txt.Hdr.Name)
+			value := strings.Join(txt.Txt, "")
+			if txt.Hdr.Class == dns.ClassNONE {
+				var kept []string
+				for _, v := range s.txt[name] {
+					if v != value {
+						kept = append(kept, v)
+					}
+				}
+				s.txt[name] = kept
+			} else {
+				s.txt[name] = append(s.txt[name], value)
+			}
+		}
+	}
+	resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	w.WriteMsg(resp)
+}
+
+func (s *updateServer) records(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.txt[name]...)
+}
+
+// startUpdateServer 在本地TCP端口上启动updateServer
+func startUpdateServer(t *testing.T) (*updateServer, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &updateServer{txt: map[string][]string{}}
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener:          listener,
+		Handler:           handler,
+		TsigSecret:        map[string]string{testTSIGKey: testTSIGSecret},
+		NotifyStartedFunc: func() { close(started) },
+		// 默认的MsgAcceptFunc会以NOTIMP拒绝UPDATE
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction {
+			if int(dh.Bits>>11)&0xF == dns.OpcodeUpdate {
+				return dns.MsgAccept
+			}
+			return dns.DefaultMsgAcceptFunc(dh)
+		},
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return handler, listener.Addr().String()
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	server, addr := startUpdateServer(t)
+	ctx := context.Background()
+	const fqdn = "_acme-challenge.www.example.test"
+
+	provider, err := newRFC2136Provider(map[string]string{
+		rfc2136EnvNameserver: addr,
+		rfc2136EnvTSIGKey:    strings.TrimSuffix(testTSIGKey, "."),
+		rfc2136EnvTSIGSecret: testTSIGSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 未配置区域时通过SOA查询找到 example.test
+	if err := provider.Present(ctx, fqdn, "value-1"); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if err := provider.Present(ctx, fqdn, "value-2"); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := server.records(fqdn + "."); len(got) != 2 || got[0] != "value-1" || got[1] != "value-2" {
+		t.Fatalf("TXT records after Present = %v", got)
+	}
+
+	// 只删除指定值的记录
+	if err := provider.CleanUp(ctx, fqdn, "value-1"); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := server.records(fqdn + "."); len(got) != 1 || got[0] != "value-2" {
+		t.Errorf("TXT records after CleanUp = %v, want [value-2]", got)
+	}
+	if server.updates != 3 {
+		t.Errorf("server received %d updates, want 3", server.updates)
+	}
+}
+
+func TestRFC2136ProviderRejected(t *testing.T) {
+	server, addr := startUpdateServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		envs map[string]string
+	}{
+		{"wrong secret", map[string]string{
+			rfc2136EnvTSIGKey:    testTSIGKey,
+			rfc2136EnvTSIGSecret: "d3Jvbmctc2VjcmV0",
+		}},
+		{"unknown key", map[string]string{
+			rfc2136EnvTSIGKey:    "other-key",
+			rfc2136EnvTSIGSecret: testTSIGSecret,
+		}},
+		{"unsigned", map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.envs[rfc2136EnvNameserver] = addr
+			tt.envs[rfc2136EnvZone] = "example.test"
+			provider, err := newRFC2136Provider(tt.envs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = provider.Present(ctx, "_acme-challenge.example.test", "value")
+			if err == nil || !strings.Contains(err.Error(), "NOTAUTH") {
+				t.Errorf("Present error = %v, want NOTAUTH", err)
+			}
+		})
+	}
+	if server.updates != 0 {
+		t.Errorf("server accepted %d updates", server.updates)
+	}
+}
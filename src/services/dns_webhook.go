@@ -0,0 +1,161 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// DNSProviderWebhook 通过HTTP webhook操作TXT记录的DNS提供商
+const DNSProviderWebhook = "webhook"
+
+// webhook请求中的action
+const (
+	webhookActionPresent = "present"
+	webhookActionCleanUp = "cleanup"
+)
+
+// 未配置超时时webhook请求的最长时间
+const defaultWebhookTimeout = 30 * time.Second
+
+var (
+	// Certificate 资源可以调用的webhook地址，逗号分隔；为空时资源不能使用webhook，配置Secret中的证书不受限制
+	// webhook请求会带上证书的envs和envsFrom中的凭证，只有命名空间权限的用户不能将它们发往任意地址
+	DNSWebhookAllowedURLs = getEnvOrDefault("DNS_WEBHOOK_ALLOWED_URLS", "")
+)
+
+// WebhookRequest AutoCert 向webhook发送的请求体
+type WebhookRequest struct {
+	Action      string            `json:"action"`      // present 或 cleanup
+	Certificate string            `json:"certificate"` // 证书名称
+	FQDN        string            `json:"fqdn"`        // TXT记录名，如 _acme-challenge.example.com.
+	Value       string            `json:"value"`       // TXT记录值
+	Envs        map[string]string `json:"envs,omitempty"`
+}
+
+// WebhookResponse webhook失败时可以返回的响应体
+type WebhookResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// webhookProvider 将TXT记录的添加和删除转发给外部webhook，用于acme.sh没有内置的DNS服务
+type webhookProvider struct {
+	certName string
+	url      string
+	envs     map[string]string
+	client   *http.Client
+}
+
+// newWebhookProvider 根据证书的webhook配置创建提供商
+func newWebhookProvider(cert *models.Certificate) (*webhookProvider, error) {
+	if cert.Webhook == nil || cert.Webhook.URL == "" {
+		return nil, fmt.Errorf("证书 %s 使用webhook DNS提供商但没有配置webhook.url", cert.Name)
+	}
+
+	timeout := defaultWebhookTimeout
+	if cert.Webhook.Timeout != "" {
+		d, err := time.ParseDuration(cert.Webhook.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("证书 %s 的webhook.timeout无效: %v", cert.Name, err)
+		}
+		timeout = d
+	}
+
+	return &webhookProvider{
+		certName: cert.Name,
+		url:      cert.Webhook.URL,
+		envs:     cert.DNSEnvs(),
+		client: &http.Client{
+			Timeout: timeout,
+			// 不跟随重定向，以免允许的地址把带有凭证的请求转发到其他地址
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// WebhookURLAllowed 检查webhook地址是否在DNS_WEBHOOK_ALLOWED_URLS中
+// 协议和主机（包括端口）必须相同，路径与允许的路径相同或位于其下；包含用户信息或 .. 的地址不允许
+func WebhookURLAllowed(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.User != nil || u.Host == "" {
+		return false
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	for _, entry := range strings.Split(DNSWebhookAllowedURLs, ",") {
+		allowed, err := url.Parse(strings.TrimSpace(entry))
+		if err != nil || allowed.Host == "" {
+			continue
+		}
+		if !strings.EqualFold(u.Scheme, allowed.Scheme) || !strings.EqualFold(u.Host, allowed.Host) {
+			continue
+		}
+		prefix := strings.TrimSuffix(allowed.Path, "/")
+		if u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *webhookProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.call(ctx, webhookActionPresent, fqdn, value)
+}
+
+func (p *webhookProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.call(ctx, webhookActionCleanUp, fqdn, value)
+}
+
+// call 发送一次webhook请求，2xx表示成功，其他状态码视为失败
+func (p *webhookProvider) call(ctx context.Context, action, fqdn, value string) error {
+	body, err := json.Marshal(&WebhookRequest{
+		Action:      action,
+		Certificate: p.certName,
+		FQDN:        strings.TrimSuffix(fqdn, ".") + ".",
+		Value:       value,
+		Envs:        p.envs,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acmeUserAgent)
+
+	utils.DebugLog("调用DNS webhook %s: %s %s", p.url, action, fqdn)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %v", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var result WebhookResponse
+	if err := json.Unmarshal(data, &result); err == nil && result.Error != "" {
+		return fmt.Errorf("webhook %s: %s: %s", action, resp.Status, result.Error)
+	}
+	return fmt.Errorf("webhook %s: %s: %s", action, resp.Status, strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// EAB Secret中默认的键名
+const (
+	eabDefaultKeyIDKey = "kid"
+	eabDefaultHMACKey  = "hmac"
+)
+
+// ResolveEAB 读取eab引用的Secret，结果写入cert.ResolvedEAB；未指定命名空间时使用defaultNamespace
+func (cs *CertificateService) ResolveEAB(ctx context.Context, cert *models.Certificate, defaultNamespace string) error {
+	cert.ResolvedEAB = nil
+	if cert.EAB == nil {
+		return nil
+	}
+
+	creds, err := readEAB(ctx, cs.clientset, "证书 "+cert.Name, cert.EAB, defaultNamespace)
+	if err != nil {
+		return err
+	}
+	cert.ResolvedEAB = creds
+	return nil
+}
+
+// readEAB 从Secret读取EAB凭证，owner用于错误信息，如"证书 example.com"
+func readEAB(ctx context.Context, clientset kubernetes.Interface, owner string, ref *models.EABConfig, defaultNamespace string) (*models.EABCredentials, error) {
+	if ref.Name == "" {
+		return nil, &ConfigError{Err: fmt.Errorf("%s的eab缺少Secret名称", owner)}
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	kidKey := ref.KeyIDKey
+	if kidKey == "" {
+		kidKey = eabDefaultKeyIDKey
+	}
+	hmacKey := ref.HMACKey
+	if hmacKey == "" {
+		hmacKey = eabDefaultHMACKey
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, &ConfigError{Err: fmt.Errorf("%s的eab引用的Secret %s/%s 不存在", owner, namespace, ref.Name)}
+	} else if err != nil {
+		return nil, fmt.Errorf("读取Secret %s/%s 失败: %v", namespace, ref.Name, err)
+	}
+
+	kid := strings.TrimSpace(string(secret.Data[kidKey]))
+	if kid == "" {
+		return nil, &ConfigError{Err: fmt.Errorf("%s的eab引用的Secret %s/%s 中没有键 %s", owner, namespace, ref.Name, kidKey)}
+	}
+	encoded := strings.TrimSpace(string(secret.Data[hmacKey]))
+	if encoded == "" {
+		return nil, &ConfigError{Err: fmt.Errorf("%s的eab引用的Secret %s/%s 中没有键 %s", owner, namespace, ref.Name, hmacKey)}
+	}
+	key, err := decodeEABKey(encoded)
+	if err != nil {
+		return nil, &ConfigError{Err: fmt.Errorf("%s的EAB HMAC密钥无效: %v", owner, err)}
+	}
+
+	utils.DebugLog("已从Secret %s/%s 读取%s的EAB凭证，key ID: %s", namespace, ref.Name, owner, kid)
+	return &models.EABCredentials{KeyID: kid, HMACKey: key}, nil
+}
+
+// decodeEABKey 解码CA提供的HMAC密钥，通常为base64url编码，也接受带填充或标准base64编码的值
+func decodeEABKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimRight(encoded, "=")
+	if key, err := base64.RawURLEncoding.DecodeString(encoded); err == nil {
+		return key, nil
+	}
+	return base64.RawStdEncoding.DecodeString(encoded)
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// ConfigError 证书配置错误，修改配置之前重试不会成功
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return "配置错误: " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveEnvs 读取envsFrom引用的Secret，结果写入cert.ResolvedEnvs
+// ResolvedEnvs不会被序列化，因此凭证不会随证书写入上下文Secret；未指定命名空间的引用使用defaultNamespace
+func (cs *CertificateService) ResolveEnvs(ctx context.Context, cert *models.Certificate, defaultNamespace string) error {
+	cert.ResolvedEnvs = nil
+	if len(cert.EnvsFrom) == 0 {
+		return nil
+	}
+
+	resolved := make(map[string]string)
+	for _, ref := range cert.EnvsFrom {
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+		if ref.Name == "" {
+			return &ConfigError{Err: fmt.Errorf("证书 %s 的envsFrom缺少Secret名称", cert.Name)}
+		}
+
+		secret, err := cs.clientset.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return &ConfigError{Err: fmt.Errorf("证书 %s 的envsFrom引用的Secret %s/%s 不存在", cert.Name, namespace, ref.Name)}
+		} else if err != nil {
+			return fmt.Errorf("读取Secret %s/%s 失败: %v", namespace, ref.Name, err)
+		}
+
+		if ref.Key == "" {
+			// 导入整个Secret
+			for key, value := range secret.Data {
+				resolved[key] = string(value)
+			}
+			continue
+		}
+
+		value, ok := secret.Data[ref.Key]
+		if !ok {
+			return &ConfigError{Err: fmt.Errorf("证书 %s 的envsFrom引用的Secret %s/%s 中没有键 %s", cert.Name, namespace, ref.Name, ref.Key)}
+		}
+		env := ref.Env
+		if env == "" {
+			env = ref.Key
+		}
+		resolved[env] = string(value)
+	}
+
+	utils.DebugLog("已从Secret读取证书 %s 的 %d 个环境变量", cert.Name, len(resolved))
+	cert.ResolvedEnvs = resolved
+	return nil
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"me.sttot/auto-cert/src/utils"
+)
+
+const http01PathPrefix = "/.well-known/acme-challenge/"
+
+var (
+	// HTTP-01验证服务的监听地址，对应chart中Service的http端口
+	HTTP01Addr = getEnvOrDefault("HTTP01_ADDR", ":8080")
+	// 临时Ingress转发到的Service，必须与控制器位于同一命名空间
+	HTTP01ServiceName = getEnvOrDefault("HTTP01_SERVICE_NAME", "autocert")
+	HTTP01ServicePort = getEnvOrDefault("HTTP01_SERVICE_PORT", "80")
+	// 临时Ingress使用的IngressClass，为空时使用集群默认值
+	HTTP01IngressClass = getEnvOrDefault("HTTP01_INGRESS_CLASS", "")
+	// 控制器所在的命名空间，临时Ingress创建在这里
+	PodNamespace = getEnvOrDefault("POD_NAMESPACE", ContextSecretNamespace)
+	// 通知CA之前自检验证地址的最长时间，超时后仍会继续验证
+	HTTP01SelfCheckTimeout = getDurationFromEnv("HTTP01_SELF_CHECK_TIMEOUT", 2*time.Minute)
+)
+
+// HTTP01Responder 内置的HTTP-01验证服务
+// 在 /.well-known/acme-challenge/ 下返回验证令牌，并在验证期间创建临时Ingress将域名的该路径转发到控制器
+type HTTP01Responder struct {
+	clientset kubernetes.Interface
+	store     *ChallengeStore
+
+	mu     sync.RWMutex
+	tokens map[string]string // 令牌到key authorization的映射
+}
+
+func NewHTTP01Responder(clientset kubernetes.Interface, store *ChallengeStore) *HTTP01Responder {
+	utils.DebugLog("创建HTTP-01验证服务")
+	return &HTTP01Responder{
+		clientset: clientset,
+		store:     store,
+		tokens:    make(map[string]string),
+	}
+}
+
+// ServeHTTP 返回令牌对应的key authorization
+func (r *HTTP01Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token, ok := strings.CutPrefix(req.URL.Path, http01PathPrefix)
+	if !ok || req.Method != http.MethodGet {
+		http.NotFound(w, req)
+		return
+	}
+
+	r.mu.RLock()
+	keyAuth, exists := r.tokens[token]
+	r.mu.RUnlock()
+	if !exists {
+		// 令牌可能由其他副本布置
+		if value, ok := r.store.get(http01StoreKey(token)); ok {
+			keyAuth, exists = string(value), true
+		}
+	}
+	if !exists {
+		utils.DebugLog("HTTP-01验证请求的令牌 %s 不存在", token)
+		http.NotFound(w, req)
+		return
+	}
+
+	utils.DebugLog("响应主机 %s 的HTTP-01验证请求", req.Host)
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, keyAuth)
+}
+
+// ListenAndServe 在HTTP01Addr上启动验证服务，ctx结束时关闭
+func (r *HTTP01Responder) ListenAndServe(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              HTTP01Addr,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	utils.InfoLog("HTTP-01验证服务监听 %s", HTTP01Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Present 记录令牌并创建转发该令牌路径的临时Ingress
+func (r *HTTP01Responder) Present(ctx context.Context, domain, token, keyAuth string) error {
+	r.mu.Lock()
+	r.tokens[token] = keyAuth
+	r.mu.Unlock()
+	if err := r.store.put(ctx, http01StoreKey(token), []byte(keyAuth)); err != nil {
+		utils.WarningLog("共享域名 %s 的HTTP-01令牌失败，其他副本将无法响应验证: %v", domain, err)
+	}
+
+	port, err := strconv.Atoi(HTTP01ServicePort)
+	if err != nil {
+		return fmt.Errorf("invalid HTTP01_SERVICE_PORT %q: %v", HTTP01ServicePort, err)
+	}
+
+	pathType := networkingv1.PathTypeExact
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      http01IngressName(domain, token),
+			Namespace: PodNamespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "autocert",
+				"autocert.sttot.me/http01":     "true",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: domain,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     http01PathPrefix + token,
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: HTTP01ServiceName,
+									Port: networkingv1.ServiceBackendPort{Number: int32(port)},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+	if HTTP01IngressClass != "" {
+		ingress.Spec.IngressClassName = &HTTP01IngressClass
+	}
+
+	utils.InfoLog("为域名 %s 创建HTTP-01临时Ingress %s/%s", domain, PodNamespace, ingress.Name)
+	_, err = r.clientset.NetworkingV1().Ingresses(PodNamespace).Create(ctx, ingress, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("创建临时Ingress失败: %v", err)
+	}
+	return nil
+}
+
+// CleanUp 删除令牌和临时Ingress
+func (r *HTTP01Responder) CleanUp(ctx context.Context, domain, token string) error {
+	r.mu.Lock()
+	delete(r.tokens, token)
+	r.mu.Unlock()
+	if err := r.store.remove(ctx, http01StoreKey(token)); err != nil {
+		utils.WarningLog("删除共享的HTTP-01令牌失败: %v", err)
+	}
+
+	name := http01IngressName(domain, token)
+	err := r.clientset.NetworkingV1().Ingresses(PodNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("删除临时Ingress %s 失败: %v", name, err)
+	}
+	return nil
+}
+
+// waitShared 等待令牌同步到验证Secret的监听缓存，之后其他副本也能响应该令牌
+func (r *HTTP01Responder) waitShared(ctx context.Context, token, keyAuth string) error {
+	return r.store.waitServed(ctx, http01StoreKey(token), []byte(keyAuth))
+}
+
+// selfCheck 通过域名访问验证地址，直到返回正确的内容或超时
+func (r *HTTP01Responder) selfCheck(ctx context.Context, domain, token, keyAuth string) error {
+	ctx, cancel := context.WithTimeout(ctx, HTTP01SelfCheckTimeout)
+	defer cancel()
+
+	url := "http://" + domain + http01PathPrefix + token
+	client := &http.Client{Timeout: 10 * time.Second}
+	var lastErr error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK && strings.TrimSpace(string(body)) == keyAuth {
+				return nil
+			}
+			err = fmt.Errorf("unexpected response %s", resp.Status)
+		}
+		lastErr = err
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %v", url, lastErr)
+		}
+	}
+}
+
+// http01StoreKey 返回令牌在验证Secret中的键
+func http01StoreKey(token string) string {
+	return "http-01." + token
+}
+
+// http01IngressName 生成临时Ingress的名称，同一令牌的Ingress名称固定
+func http01IngressName(domain, token string) string {
+	sum := sha256.Sum256([]byte(domain + "/" + token))
+	return "autocert-http01-" + hex.EncodeToString(sum[:])[:16]
+}
+
+// httpSolver 通过HTTP01Responder完成http-01验证
+type httpSolver struct {
+	responder *HTTP01Responder
+
+	presented []http01Token
+}
+
+type http01Token struct {
+	domain  string
+	token   string
+	keyAuth string
+}
+
+func (s *httpSolver) Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return fmt.Errorf("计算域名 %s 的验证内容失败: %v", domain, err)
+	}
+	if err := s.responder.Present(ctx, domain, chal.Token, keyAuth); err != nil {
+		return err
+	}
+	s.presented = append(s.presented, http01Token{domain: domain, token: chal.Token, keyAuth: keyAuth})
+	return nil
+}
+
+// Wait 等待令牌同步到所有副本并且Ingress生效；集群内无法访问自身域名时自检会失败，此时仅记录警告并继续验证
+func (s *httpSolver) Wait(ctx context.Context) error {
+	for _, t := range s.presented {
+		if err := s.responder.waitShared(ctx, t.token, t.keyAuth); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			utils.WarningLog("域名 %s 的HTTP-01令牌未同步到其他副本，继续提交验证: %v", t.domain, err)
+		}
+		utils.DebugLog("检查域名 %s 的HTTP-01验证地址", t.domain)
+		if err := s.responder.selfCheck(ctx, t.domain, t.token, t.keyAuth); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			utils.WarningLog("域名 %s 的HTTP-01验证地址自检未通过，继续提交验证: %v", t.domain, err)
+		}
+	}
+	return nil
+}
+
+func (s *httpSolver) CleanUp(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	return s.responder.CleanUp(ctx, domain, chal.Token)
+}
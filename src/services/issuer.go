@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+const (
+	// DefaultIssuerName 证书未指定issuer时使用的签发者
+	DefaultIssuerName = "acme"
+
+	IssuerTypeACME = "acme"
+	IssuerTypeCA   = "ca"
+)
+
+// Issuer 证书签发后端
+type Issuer interface {
+	// Issue 签发新证书，结果写入cert.CertData和cert.KeyData
+	Issue(ctx context.Context, cert *models.Certificate) error
+	// Renew 续签证书，结果写入cert.CertData和cert.KeyData
+	Renew(ctx context.Context, cert *models.Certificate) error
+}
+
+// Revoker 由能够吊销证书的签发者实现
+type Revoker interface {
+	// Revoke 吊销cert.CertData中的证书
+	Revoke(ctx context.Context, cert *models.Certificate) error
+}
+
+// IssuerRegistry 按名称管理签发者，内置的acme签发者始终可用
+type IssuerRegistry struct {
+	clientset   kubernetes.Interface
+	acmeService *AcmeService
+
+	mu      sync.RWMutex
+	issuers map[string]Issuer
+}
+
+func NewIssuerRegistry(clientset kubernetes.Interface, acmeService *AcmeService) *IssuerRegistry {
+	utils.DebugLog("创建签发者注册表")
+	r := &IssuerRegistry{
+		clientset:   clientset,
+		acmeService: acmeService,
+		issuers:     make(map[string]Issuer),
+	}
+	r.issuers[DefaultIssuerName] = acmeService
+	return r
+}
+
+// Register 注册签发者，同名签发者会被替换
+func (r *IssuerRegistry) Register(name string, issuer Issuer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.issuers[name] = issuer
+	utils.DebugLog("注册签发者 %s", name)
+}
+
+// Get 获取签发者，name为空时返回默认签发者
+func (r *IssuerRegistry) Get(name string) (Issuer, error) {
+	if name == "" {
+		name = DefaultIssuerName
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	issuer, ok := r.issuers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown issuer %q", name)
+	}
+	return issuer, nil
+}
+
+// ACME 返回内置的ACME签发者
+func (r *IssuerRegistry) ACME() *AcmeService {
+	return r.acmeService
+}
+
+// Type 返回签发者的类型，name为空时返回默认签发者的类型
+func (r *IssuerRegistry) Type(name string) (string, error) {
+	issuer, err := r.Get(name)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := issuer.(*CAIssuer); ok {
+		return IssuerTypeCA, nil
+	}
+	return IssuerTypeACME, nil
+}
+
+// Configure 根据配置文件中的issuers重建签发者列表
+func (r *IssuerRegistry) Configure(configs []models.IssuerConfig) error {
+	issuers := map[string]Issuer{
+		DefaultIssuerName: r.acmeService,
+	}
+
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return fmt.Errorf("issuer name is empty")
+		}
+		if _, exists := issuers[cfg.Name]; exists && cfg.Name != DefaultIssuerName {
+			return fmt.Errorf("duplicate issuer %q", cfg.Name)
+		}
+
+		switch cfg.Type {
+		case IssuerTypeACME:
+			issuers[cfg.Name] = r.acmeService
+		case IssuerTypeCA:
+			issuer, err := NewCAIssuer(r.clientset, cfg.CA)
+			if err != nil {
+				return fmt.Errorf("issuer %q: %v", cfg.Name, err)
+			}
+			issuers[cfg.Name] = issuer
+		default:
+			return fmt.Errorf("issuer %q: unknown type %q", cfg.Name, cfg.Type)
+		}
+		utils.DebugLog("配置签发者 %s，类型: %s", cfg.Name, cfg.Type)
+	}
+
+	r.mu.Lock()
+	r.issuers = issuers
+	r.mu.Unlock()
+	return nil
+}
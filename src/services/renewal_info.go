@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+// 服务器没有返回Retry-After时再次查询续签信息的间隔
+const defaultRenewalInfoRetryAfter = 6 * time.Hour
+
+// RenewalInfo CA通过ARI(ACME Renewal Information)建议的续签窗口
+type RenewalInfo struct {
+	WindowStart    time.Time
+	WindowEnd      time.Time
+	ExplanationURL string
+	// 在此时间之前不需要再次查询，来自响应的Retry-After
+	NextPoll time.Time
+	// 查询的证书序列号，证书更换后缓存失效
+	Serial string
+}
+
+// ChooseRenewalTime 在建议窗口内随机选择续签时间，避免所有客户端在同一时刻续签
+// previous为该证书上次的选择，序列号和窗口都没有变化时原样返回，CA调整窗口后重新选择
+func (ri *RenewalInfo) ChooseRenewalTime(previous *models.ARIRenewal) *models.ARIRenewal {
+	if previous != nil && previous.Serial == ri.Serial &&
+		previous.WindowStart.Equal(ri.WindowStart) && previous.WindowEnd.Equal(ri.WindowEnd) {
+		return previous
+	}
+
+	renewAt := ri.WindowStart
+	if window := ri.WindowEnd.Sub(ri.WindowStart); window > 0 {
+		renewAt = renewAt.Add(time.Duration(rand.Int63n(int64(window))))
+	}
+	return &models.ARIRenewal{
+		Serial:      ri.Serial,
+		WindowStart: ri.WindowStart,
+		WindowEnd:   ri.WindowEnd,
+		RenewAt:     renewAt,
+	}
+}
+
+// RenewalInfoProvider 由支持查询CA建议续签时间的签发者实现
+type RenewalInfoProvider interface {
+	RenewalInfo(ctx context.Context, cert *models.Certificate, leaf *x509.Certificate) (*RenewalInfo, error)
+}
+
+// RenewalInfo 从ACME服务器目录中的renewalInfo地址查询证书的建议续签窗口
+func (a *AcmeService) RenewalInfo(ctx context.Context, cert *models.Certificate, leaf *x509.Certificate) (*RenewalInfo, error) {
+	server, err := a.CertServer(cert)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := a.Directory(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	if dir.RenewalInfo == "" {
+		return nil, fmt.Errorf("server %s does not support renewal info", dir.URL)
+	}
+
+	certID, err := renewalInfoCertID(leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(dir.RenewalInfo, "/")+"/"+certID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", acmeUserAgent)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query renewal info: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("query renewal info: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var body struct {
+		SuggestedWindow struct {
+			Start time.Time `json:"start"`
+			End   time.Time `json:"end"`
+		} `json:"suggestedWindow"`
+		ExplanationURL string `json:"explanationURL"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("parse renewal info: %v", err)
+	}
+	if body.SuggestedWindow.Start.IsZero() || body.SuggestedWindow.End.Before(body.SuggestedWindow.Start) {
+		return nil, fmt.Errorf("invalid suggested window %s - %s", body.SuggestedWindow.Start, body.SuggestedWindow.End)
+	}
+
+	info := &RenewalInfo{
+		WindowStart:    body.SuggestedWindow.Start,
+		WindowEnd:      body.SuggestedWindow.End,
+		ExplanationURL: body.ExplanationURL,
+		NextPoll:       time.Now().Add(retryAfter(resp.Header.Get("Retry-After"))),
+		Serial:         leaf.SerialNumber.Text(16),
+	}
+	utils.DebugLog("证书 %s 的建议续签窗口: %s - %s", cert.Name, info.WindowStart.Format(time.RFC3339), info.WindowEnd.Format(time.RFC3339))
+	return info, nil
+}
+
+// renewalInfoCertID 按ARI规范生成证书标识: base64url(AKI).base64url(序列号)
+func renewalInfoCertID(leaf *x509.Certificate) (string, error) {
+	if len(leaf.AuthorityKeyId) == 0 {
+		return "", fmt.Errorf("certificate has no authority key identifier")
+	}
+
+	// 序列号使用DER INTEGER的内容，最高位为1时需要补0以保持为正数
+	serial := leaf.SerialNumber.Bytes()
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(leaf.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial), nil
+}
+
+// retryAfter 解析Retry-After响应头，支持秒数和HTTP日期两种格式
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return defaultRenewalInfoRetryAfter
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return defaultRenewalInfoRetryAfter
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"me.sttot/auto-cert/src/models"
+)
+
+// stubLeaf 由本地ACME服务器的测试CA签发一张证书
+func stubLeaf(t *testing.T, stub *acmeStub, domains ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := createCSR(key, domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := stub.issue(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(chain)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestRenewalInfo(t *testing.T) {
+	windowStart := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	windowEnd := windowStart.Add(48 * time.Hour)
+
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantPoll   time.Duration
+	}{
+		{"seconds", "3600", time.Hour},
+		{"http date", time.Now().Add(2 * time.Hour).UTC().Format(http.TimeFormat), 2 * time.Hour},
+		{"missing", "", defaultRenewalInfoRetryAfter},
+		{"invalid", "soon", defaultRenewalInfoRetryAfter},
+		{"date in the past", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), defaultRenewalInfoRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newACMEStub(t)
+			leaf := stubLeaf(t, stub, "example.test")
+			wantID, err := renewalInfoCertID(leaf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stub.renewalInfo = func(w http.ResponseWriter, r *http.Request) {
+				if id := strings.TrimPrefix(r.URL.Path, "/renewal-info/"); id != wantID {
+					t.Errorf("renewal info requested for %q, want %q", id, wantID)
+				}
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"suggestedWindow": map[string]time.Time{"start": windowStart, "end": windowEnd},
+					"explanationURL":  "https://ca.example.test/incident",
+				})
+			}
+
+			service := NewAcmeServiceWithHTTPClient(stub.server.Client(), fake.NewSimpleClientset(), nil, nil)
+			cert := &models.Certificate{Name: "example", Server: stub.URL()}
+			info, err := service.RenewalInfo(context.Background(), cert, leaf)
+			if err != nil {
+				t.Fatalf("RenewalInfo: %v", err)
+			}
+
+			if !info.WindowStart.Equal(windowStart) || !info.WindowEnd.Equal(windowEnd) {
+				t.Errorf("window = %s - %s, want %s - %s", info.WindowStart, info.WindowEnd, windowStart, windowEnd)
+			}
+			if info.ExplanationURL != "https://ca.example.test/incident" {
+				t.Errorf("explanation URL = %q", info.ExplanationURL)
+			}
+			if info.Serial != leaf.SerialNumber.Text(16) {
+				t.Errorf("serial = %s, want %s", info.Serial, leaf.SerialNumber.Text(16))
+			}
+			// HTTP日期只精确到秒
+			if diff := time.Until(info.NextPoll) - tt.wantPoll; diff < -2*time.Second || diff > 2*time.Second {
+				t.Errorf("next poll in %s, want %s", time.Until(info.NextPoll), tt.wantPoll)
+			}
+		})
+	}
+}
+
+func TestRenewalInfoUnsupported(t *testing.T) {
+	stub := newACMEStub(t)
+	leaf := stubLeaf(t, stub, "example.test")
+
+	service := NewAcmeServiceWithHTTPClient(stub.server.Client(), fake.NewSimpleClientset(), nil, nil)
+	cert := &models.Certificate{Name: "example", Server: stub.URL()}
+	if _, err := service.RenewalInfo(context.Background(), cert, leaf); err == nil {
+		t.Fatal("RenewalInfo succeeded without renewalInfo in the directory")
+	}
+}
+
+func TestChooseRenewalTime(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := &RenewalInfo{WindowStart: start, WindowEnd: start.Add(24 * time.Hour), Serial: "01"}
+
+	seen := make(map[time.Time]bool)
+	for i := 0; i < 20; i++ {
+		choice := info.ChooseRenewalTime(nil)
+		if choice.RenewAt.Before(info.WindowStart) || !choice.RenewAt.Before(info.WindowEnd) {
+			t.Fatalf("renewal time %s is outside the window %s - %s", choice.RenewAt, info.WindowStart, info.WindowEnd)
+		}
+		if choice.Serial != "01" {
+			t.Errorf("choice serial = %q", choice.Serial)
+		}
+		seen[choice.RenewAt] = true
+	}
+	if len(seen) == 1 {
+		t.Error("every choice picked the same instant")
+	}
+
+	// 序列号和窗口不变时沿用上次的选择
+	previous := info.ChooseRenewalTime(nil)
+	if got := info.ChooseRenewalTime(previous); got != previous {
+		t.Errorf("choice changed from %s to %s for the same window", previous.RenewAt, got.RenewAt)
+	}
+
+	// CA调整窗口或证书更换后重新选择
+	moved := &RenewalInfo{WindowStart: start.Add(-48 * time.Hour), WindowEnd: start.Add(-24 * time.Hour), Serial: "01"}
+	if got := moved.ChooseRenewalTime(previous); got == previous || got.RenewAt.After(moved.WindowEnd) {
+		t.Errorf("choice for the moved window = %+v", got)
+	}
+	renewed := &RenewalInfo{WindowStart: start, WindowEnd: start.Add(24 * time.Hour), Serial: "02"}
+	if got := renewed.ChooseRenewalTime(previous); got == previous || got.Serial != "02" {
+		t.Errorf("choice for the new serial = %+v", got)
+	}
+
+	// 窗口为空时使用窗口开始时间
+	empty := &RenewalInfo{WindowStart: start, WindowEnd: start, Serial: "01"}
+	if got := empty.ChooseRenewalTime(nil); !got.RenewAt.Equal(start) {
+		t.Errorf("renewal time for an empty window = %s, want %s", got.RenewAt, start)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"me.sttot/auto-cert/src/models"
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// 保存配置Secret中证书状态的ConfigMap
+	StatusConfigMapName = getEnvOrDefault("STATUS_CONFIGMAP_NAME", "autocert-status")
+)
+
+// StatusService 记录证书的签发状态
+// 名称为 namespace/name 的证书来自 Certificate 资源，状态写入其status子资源；
+// 其余证书来自配置Secret，状态以JSON形式写入状态ConfigMap中以证书名称为键的条目
+type StatusService struct {
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+}
+
+func NewStatusService(clientset kubernetes.Interface, dynamicClient dynamic.Interface) *StatusService {
+	utils.DebugLog("创建状态服务")
+	return &StatusService{
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+	}
+}
+
+// UpdateStatus 读取证书当前状态，调用mutate修改后写回，遇到冲突时重试
+// 每次重试都会重新调用mutate，mutate只能修改status，不能有其他副作用
+func (ss *StatusService) UpdateStatus(ctx context.Context, name string, mutate func(status *models.CertificateStatus)) error {
+	if namespace, resourceName, ok := strings.Cut(name, "/"); ok {
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			return ss.updateResourceStatus(ctx, namespace, resourceName, mutate)
+		})
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return ss.updateConfigMapStatus(ctx, name, mutate)
+	})
+}
+
+// GetStatus 获取证书当前状态，没有记录时返回空状态
+func (ss *StatusService) GetStatus(ctx context.Context, name string) (*models.CertificateStatus, error) {
+	if namespace, resourceName, ok := strings.Cut(name, "/"); ok {
+		u, err := ss.dynamicClient.Resource(models.CertificateGVR).Namespace(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return resourceStatus(u)
+	}
+
+	cm, err := ss.clientset.CoreV1().ConfigMaps(ContextSecretNamespace).Get(ctx, StatusConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &models.CertificateStatus{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return configMapStatus(cm, name)
+}
+
+// updateResourceStatus 通过status子资源更新 Certificate 资源的状态
+func (ss *StatusService) updateResourceStatus(ctx context.Context, namespace, name string, mutate func(status *models.CertificateStatus)) error {
+	resources := ss.dynamicClient.Resource(models.CertificateGVR).Namespace(namespace)
+	u, err := resources.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	status, err := resourceStatus(u)
+	if err != nil {
+		return err
+	}
+	mutate(status)
+	status.ObservedGeneration = u.GetGeneration()
+
+	statusMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return fmt.Errorf("convert status: %v", err)
+	}
+	if err := unstructured.SetNestedField(u.Object, statusMap, "status"); err != nil {
+		return fmt.Errorf("set status: %v", err)
+	}
+
+	_, err = resources.UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	return err
+}
+
+// updateConfigMapStatus 更新状态ConfigMap中的一条证书状态，ConfigMap不存在时创建
+func (ss *StatusService) updateConfigMapStatus(ctx context.Context, name string, mutate func(status *models.CertificateStatus)) error {
+	configMaps := ss.clientset.CoreV1().ConfigMaps(ContextSecretNamespace)
+	cm, err := configMaps.Get(ctx, StatusConfigMapName, metav1.GetOptions{})
+	notFound := apierrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return err
+	}
+	if notFound {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      StatusConfigMapName,
+				Namespace: ContextSecretNamespace,
+			},
+		}
+	}
+
+	status, err := configMapStatus(cm, name)
+	if err != nil {
+		return err
+	}
+	mutate(status)
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal status: %v", err)
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[name] = string(data)
+
+	if notFound {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// 并发创建时按冲突处理，由RetryOnConflict重新读取
+			return apierrors.NewConflict(corev1.Resource("configmaps"), StatusConfigMapName, err)
+		}
+		return err
+	}
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// resourceStatus 从 Certificate 资源中解析状态
+func resourceStatus(u *unstructured.Unstructured) (*models.CertificateStatus, error) {
+	status := &models.CertificateStatus{}
+	statusMap, found, err := unstructured.NestedMap(u.Object, "status")
+	if err != nil || !found {
+		return status, nil
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(statusMap, status); err != nil {
+		return nil, fmt.Errorf("parse status: %v", err)
+	}
+	return status, nil
+}
+
+// configMapStatus 从状态ConfigMap中解析一条证书状态
+func configMapStatus(cm *corev1.ConfigMap, name string) (*models.CertificateStatus, error) {
+	status := &models.CertificateStatus{}
+	data, ok := cm.Data[name]
+	if !ok {
+		return status, nil
+	}
+	if err := json.Unmarshal([]byte(data), status); err != nil {
+		return nil, fmt.Errorf("parse status of %s: %v", name, err)
+	}
+	return status, nil
+}
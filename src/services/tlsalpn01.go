@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"me.sttot/auto-cert/src/utils"
+)
+
+var (
+	// TLS-ALPN-01验证服务的监听地址，对应chart中Service的https端口
+	TLSALPN01Addr = getEnvOrDefault("TLS_ALPN01_ADDR", ":8443")
+)
+
+// TLSALPN01Responder 内置的TLS-ALPN-01验证服务
+// 只响应协商 acme-tls/1 协议的连接，按SNI返回包含acmeIdentifier扩展的自签名证书
+type TLSALPN01Responder struct {
+	store *ChallengeStore
+
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate // 域名到验证证书的映射
+}
+
+func NewTLSALPN01Responder(store *ChallengeStore) *TLSALPN01Responder {
+	utils.DebugLog("创建TLS-ALPN-01验证服务")
+	return &TLSALPN01Responder{
+		store: store,
+		certs: make(map[string]*tls.Certificate),
+	}
+}
+
+// TLSConfig 返回验证服务使用的TLS配置
+func (r *TLSALPN01Responder) TLSConfig() *tls.Config {
+	return &tls.Config{
+		NextProtos:     []string{acme.ALPNProto},
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+}
+
+// getCertificate 按SNI查找验证证书，非acme-tls/1的连接直接拒绝
+func (r *TLSALPN01Responder) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	supported := false
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("client does not support %s", acme.ALPNProto)
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	r.mu.RLock()
+	cert, ok := r.certs[domain]
+	r.mu.RUnlock()
+	if !ok {
+		// 验证证书可能由其他副本布置
+		if data, found := r.store.get(tlsALPN01StoreKey(domain)); found {
+			if shared, err := tls.X509KeyPair(data, data); err == nil {
+				cert, ok = &shared, true
+			} else {
+				utils.WarningLog("解析共享的域名 %s 的验证证书失败: %v", domain, err)
+			}
+		}
+	}
+	if !ok {
+		utils.DebugLog("TLS-ALPN-01验证请求的域名 %s 没有验证证书", domain)
+		return nil, fmt.Errorf("no challenge certificate for %q", domain)
+	}
+
+	utils.DebugLog("响应域名 %s 的TLS-ALPN-01验证请求", domain)
+	return cert, nil
+}
+
+// ListenAndServe 在TLSALPN01Addr上启动验证服务，完成握手后即关闭连接，ctx结束时停止
+func (r *TLSALPN01Responder) ListenAndServe(ctx context.Context) error {
+	listener, err := tls.Listen("tcp", TLSALPN01Addr, r.TLSConfig())
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	utils.InfoLog("TLS-ALPN-01验证服务监听 %s", TLSALPN01Addr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go r.handle(conn)
+	}
+}
+
+// handle 完成TLS握手，CA只需要检查握手中的证书
+func (r *TLSALPN01Responder) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.(*tls.Conn).Handshake(); err != nil {
+		utils.DebugLog("TLS-ALPN-01握手失败 %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// Present 记录域名的验证证书，并共享给其他副本
+func (r *TLSALPN01Responder) Present(ctx context.Context, domain string, cert tls.Certificate) {
+	domain = strings.ToLower(domain)
+	r.mu.Lock()
+	r.certs[domain] = &cert
+	r.mu.Unlock()
+
+	data, err := encodeChallengeCert(cert)
+	if err == nil {
+		err = r.store.put(ctx, tlsALPN01StoreKey(domain), data)
+	}
+	if err != nil {
+		utils.WarningLog("共享域名 %s 的TLS-ALPN-01验证证书失败，其他副本将无法响应验证: %v", domain, err)
+	}
+}
+
+// CleanUp 删除域名的验证证书
+func (r *TLSALPN01Responder) CleanUp(ctx context.Context, domain string) {
+	domain = strings.ToLower(domain)
+	r.mu.Lock()
+	delete(r.certs, domain)
+	r.mu.Unlock()
+
+	if err := r.store.remove(ctx, tlsALPN01StoreKey(domain)); err != nil {
+		utils.WarningLog("删除共享的TLS-ALPN-01验证证书失败: %v", err)
+	}
+}
+
+// waitShared 等待域名的验证证书同步到验证Secret的监听缓存，之后其他副本也能响应该域名
+func (r *TLSALPN01Responder) waitShared(ctx context.Context, domain string) error {
+	domain = strings.ToLower(domain)
+	r.mu.Lock()
+	cert, ok := r.certs[domain]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	data, err := encodeChallengeCert(*cert)
+	if err != nil {
+		return err
+	}
+	return r.store.waitServed(ctx, tlsALPN01StoreKey(domain), data)
+}
+
+// tlsALPN01StoreKey 返回域名在验证Secret中的键
+func tlsALPN01StoreKey(domain string) string {
+	return "tls-alpn-01." + domain
+}
+
+// encodeChallengeCert 将验证证书和私钥编码到同一段PEM中
+func encodeChallengeCert(cert tls.Certificate) ([]byte, error) {
+	var data []byte
+	for _, der := range cert.Certificate {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...), nil
+}
+
+// tlsALPNSolver 通过TLSALPN01Responder完成tls-alpn-01验证
+type tlsALPNSolver struct {
+	responder *TLSALPN01Responder
+
+	presented []string
+}
+
+func (s *tlsALPNSolver) Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	cert, err := client.TLSALPN01ChallengeCert(chal.Token, domain)
+	if err != nil {
+		return fmt.Errorf("生成域名 %s 的验证证书失败: %v", domain, err)
+	}
+	utils.InfoLog("为域名 %s 布置TLS-ALPN-01验证证书", domain)
+	s.responder.Present(ctx, domain, cert)
+	s.presented = append(s.presented, domain)
+	return nil
+}
+
+// Wait 等待验证证书同步到所有副本，CA的验证连接可能被Service转发到任意副本
+func (s *tlsALPNSolver) Wait(ctx context.Context) error {
+	for _, domain := range s.presented {
+		if err := s.responder.waitShared(ctx, domain); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			utils.WarningLog("域名 %s 的TLS-ALPN-01验证证书未同步到其他副本，继续提交验证: %v", domain, err)
+		}
+	}
+	return nil
+}
+
+func (s *tlsALPNSolver) CleanUp(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	s.responder.CleanUp(ctx, domain)
+	return nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestTLSALPN01Responder(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key}
+	chal := &acme.Challenge{Type: ChallengeTLSALPN01, Token: "tls-alpn-token"}
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responder := NewTLSALPN01Responder(NewChallengeStore(nil))
+	addr := serveTLSALPN01(t, responder)
+	solver := &tlsALPNSolver{responder: responder}
+	ctx := context.Background()
+	if err := solver.Present(ctx, client, "example.test", chal); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+
+	// 证书包含关键的acmeIdentifier扩展，内容为key authorization的SHA-256
+	if err := checkTLSALPN01(addr, "example.test", keyAuth); err != nil {
+		t.Errorf("acme-tls/1 handshake: %v", err)
+	}
+	if err := checkTLSALPN01(addr, "example.test", keyAuth+"x"); err == nil {
+		t.Error("acmeIdentifier matched a different key authorization")
+	}
+
+	// 不协商acme-tls/1的连接被拒绝
+	for _, protos := range [][]string{nil, {"h2", "http/1.1"}} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			ServerName:         "example.test",
+			NextProtos:         protos,
+			InsecureSkipVerify: true,
+		})
+		if err == nil {
+			conn.Close()
+			t.Errorf("handshake with ALPN %v succeeded", protos)
+		}
+	}
+
+	// 没有布置验证的域名被拒绝
+	if err := checkTLSALPN01(addr, "other.test", keyAuth); err == nil {
+		t.Error("handshake for an unknown domain succeeded")
+	}
+
+	if err := solver.CleanUp(ctx, client, "example.test", chal); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if err := checkTLSALPN01(addr, "example.test", keyAuth); err == nil {
+		t.Error("handshake succeeded after CleanUp")
+	}
+}